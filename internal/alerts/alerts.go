@@ -0,0 +1,325 @@
+// Price and valuation threshold alerts.
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/srackham/cryptor/internal/portfolio"
+)
+
+// Rule metrics.
+const (
+	PRICE = "price" // Asset unit price in USD
+	VALUE = "value" // Portfolio value in USD
+	GAINS = "gains" // Portfolio value less cost in USD (or as a percentage of the cost)
+)
+
+// Rule operators.
+const (
+	ABOVE = "above" // The current metric is greater than the threshold
+	BELOW = "below" // The current metric is less than the threshold
+	UP    = "up"    // The metric has increased by the threshold or more since the baseline valuation
+	DOWN  = "down"  // The metric has decreased by the threshold or more since the baseline valuation
+)
+
+// Rule is an alert rule e.g. "BTC price below 50000" or "aggregate value up 10% in 24h".
+// Rules are formatted "SUBJECT [portfolio] METRIC OPERATOR THRESHOLD[%] [in PERIOD]".
+type Rule struct {
+	Text      string        // The rule text
+	Subject   string        // Asset symbol (price rules) or portfolio name
+	Metric    string        // "price", "value" or "gains"
+	Operator  string        // "above", "below", "up" or "down"
+	Threshold float64       // Threshold amount in USD or percentage
+	Percent   bool          // True if the threshold is a percentage
+	Period    time.Duration // Change rule period (zero if the rule has no period)
+}
+
+type Rules []Rule
+
+// Alert is a triggered alert rule.
+type Alert struct {
+	Rule    string `yaml:"rule"    json:"rule"`    // The rule text
+	Message string `yaml:"message" json:"message"` // Alert description
+	Date    string `yaml:"date"    json:"date"`    // The valuation date formatted "YYYY-MM-DD"
+	Time    string `yaml:"time"    json:"time"`    // The valuation time formatted "hh:mm:ss"
+}
+
+type Alerts []Alert
+
+// ParseRule parses alert rule `text`.
+func ParseRule(text string) (Rule, error) {
+	res := Rule{Text: text}
+	fields := strings.Fields(text)
+	if len(fields) > 1 && strings.ToLower(fields[1]) == "portfolio" {
+		fields = append(fields[:1], fields[2:]...)
+	}
+	if len(fields) != 4 && len(fields) != 6 {
+		return res, fmt.Errorf("invalid alert rule: \"%s\"", text)
+	}
+	res.Subject = fields[0]
+	res.Metric = strings.ToLower(fields[1])
+	res.Operator = strings.ToLower(fields[2])
+	switch res.Metric {
+	case PRICE:
+		res.Subject = strings.ToUpper(res.Subject)
+	case VALUE, GAINS:
+		if !portfolio.IsValidName(res.Subject) {
+			return res, fmt.Errorf("invalid alert rule portfolio name: \"%s\"", text)
+		}
+	default:
+		return res, fmt.Errorf("invalid alert rule metric: \"%s\"", text)
+	}
+	if res.Operator != ABOVE && res.Operator != BELOW && res.Operator != UP && res.Operator != DOWN {
+		return res, fmt.Errorf("invalid alert rule operator: \"%s\"", text)
+	}
+	threshold := strings.ReplaceAll(strings.TrimPrefix(fields[3], "$"), ",", "")
+	if strings.HasSuffix(threshold, "%") {
+		res.Percent = true
+		threshold = strings.TrimSuffix(threshold, "%")
+	}
+	var err error
+	res.Threshold, err = strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return res, fmt.Errorf("invalid alert rule threshold: \"%s\"", text)
+	}
+	isChange := res.Operator == UP || res.Operator == DOWN
+	if isChange && res.Threshold < 0 {
+		return res, fmt.Errorf("alert rule threshold cannot be negative: \"%s\"", text)
+	}
+	if res.Percent && !isChange && res.Metric != GAINS {
+		return res, fmt.Errorf("alert rule percentage threshold requires up, down or gains: \"%s\"", text)
+	}
+	if len(fields) == 6 {
+		if strings.ToLower(fields[4]) != "in" || !isChange {
+			return res, fmt.Errorf("invalid alert rule: \"%s\"", text)
+		}
+		res.Period, err = parsePeriod(fields[5])
+		if err != nil {
+			return res, fmt.Errorf("invalid alert rule period: \"%s\"", text)
+		}
+	}
+	return res, nil
+}
+
+// ParseRules parses a list of alert rules.
+func ParseRules(texts []string) (Rules, error) {
+	res := Rules{}
+	for _, text := range texts {
+		r, err := ParseRule(text)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// parsePeriod parses a Go duration string (e.g. "12h") or a number of days (e.g. "7d").
+func parsePeriod(s string) (time.Duration, error) {
+	var res time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		res = time.Duration(n) * 24 * time.Hour
+	} else {
+		res, err = time.ParseDuration(s)
+	}
+	if err == nil && res <= 0 {
+		err = fmt.Errorf("period must be greater than zero")
+	}
+	return res, err
+}
+
+// Evaluate returns the alerts triggered by the `current` valuations.
+// Change rules ("up" and "down") compare current valuations with a `saved` valuation before the current valuation (the
+// baseline): the oldest valuation within the rule's period or, if the rule has no period, the latest valuation.
+// A change rule is not triggered if there is no baseline.
+// Rules that do not apply to the current valuations (price rules for assets that are not held and gains rules for
+// portfolios with no cost) are skipped and a warning is written to `stderr`.
+func (rules Rules) Evaluate(current portfolio.Portfolios, saved portfolio.Portfolios, stderr io.Writer) (Alerts, error) {
+	res := Alerts{}
+	for _, r := range rules {
+		p, value, err := r.current(current)
+		if errors.Is(err, errNotApplicable) {
+			fmt.Fprintf(stderr, "WARNING: %s\n", err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var message string
+		switch r.Operator {
+		case ABOVE, BELOW:
+			if (r.Operator == ABOVE && value > r.Threshold) || (r.Operator == BELOW && value < r.Threshold) {
+				message = fmt.Sprintf("%s %s %s is %s %s", r.Subject, r.Metric, r.format(value), r.Operator, r.format(r.Threshold))
+			}
+		case UP, DOWN:
+			base, baseValue, ok := r.baseline(p, saved)
+			if !ok {
+				continue
+			}
+			change := value - baseValue
+			if r.Percent {
+				if baseValue == 0 {
+					continue
+				}
+				change = change / math.Abs(baseValue) * 100
+			}
+			if r.Operator == DOWN {
+				change = -change
+			}
+			if change >= r.Threshold {
+				message = fmt.Sprintf("%s %s %.2f USD is %s %s since %s %s", r.Subject, r.Metric, value, r.Operator, r.format(change), base.Date, base.Time)
+			}
+		}
+		if message != "" {
+			res = append(res, Alert{Rule: r.Text, Message: message, Date: p.Date, Time: p.Time})
+		}
+	}
+	return res, nil
+}
+
+// errNotApplicable is returned for rules that do not apply to the current valuations.
+var errNotApplicable = errors.New("skipped alert rule")
+
+// current returns the current valuation that the rule applies to and the rule's metric value.
+func (r Rule) current(ps portfolio.Portfolios) (portfolio.Portfolio, float64, error) {
+	if r.Metric == PRICE {
+		for _, p := range ps {
+			if i := p.Assets.Find(r.Subject); i != -1 {
+				return p, p.Assets[i].Price, nil
+			}
+		}
+		return portfolio.Portfolio{}, 0, fmt.Errorf("%w: asset is not held: \"%s\"", errNotApplicable, r.Text)
+	}
+	i := ps.FindByName(r.Subject)
+	if i == -1 {
+		return portfolio.Portfolio{}, 0, fmt.Errorf("alert rule portfolio not found: \"%s\"", r.Text)
+	}
+	value, ok := r.metric(ps[i])
+	if !ok {
+		return portfolio.Portfolio{}, 0, fmt.Errorf("%w: portfolio has no cost: \"%s\"", errNotApplicable, r.Text)
+	}
+	return ps[i], value, nil
+}
+
+// metric returns the rule's metric value for valuation `p`; `ok` is false if `p` has no value for the metric.
+func (r Rule) metric(p portfolio.Portfolio) (value float64, ok bool) {
+	switch r.Metric {
+	case PRICE:
+		if i := p.Assets.Find(r.Subject); i != -1 && p.Assets[i].Price > 0 {
+			return p.Assets[i].Price, true
+		}
+		return 0, false
+	case GAINS:
+		if p.Cost <= 0 {
+			return 0, false
+		}
+		if r.Percent && r.Operator != UP && r.Operator != DOWN {
+			return (p.Value - p.Cost) / p.Cost * 100, true
+		}
+		return p.Value - p.Cost, true
+	default:
+		return p.Value, true
+	}
+}
+
+// periodTolerance is the fraction of a rule's period that a baseline valuation can be older than the period; it allows
+// for variations in the times of scheduled valuations e.g. a daily valuation that runs a few seconds more than 24h after
+// the previous one.
+const periodTolerance = 0.1
+
+// baseline returns the `saved` valuation before current valuation `p` that the rule's metric change is measured from.
+// If the rule has a period the baseline is the oldest valuation within the period (plus the period tolerance), otherwise
+// it is the latest valuation. Only valuations with a value for the rule's metric are considered.
+// `ok` is false if there is no baseline.
+func (r Rule) baseline(p portfolio.Portfolio, saved portfolio.Portfolios) (base portfolio.Portfolio, value float64, ok bool) {
+	start, err := r.start(p.Date, p.Time)
+	if err != nil {
+		return base, 0, false
+	}
+	found := false
+	for _, s := range saved {
+		t := s.Date + " " + s.Time
+		if t >= p.Date+" "+p.Time || t < start || (r.Metric != PRICE && s.Name != r.Subject) {
+			continue
+		}
+		if found && ((r.Period > 0 && t >= base.Date+" "+base.Time) || (r.Period == 0 && t <= base.Date+" "+base.Time)) {
+			continue
+		}
+		if v, hasValue := r.metric(s); hasValue {
+			base, value, found = s, v, true
+		}
+	}
+	return base, value, found
+}
+
+// start returns the earliest baseline date and time (formatted "YYYY-MM-DD hh:mm:ss") for a current valuation dated
+// `date` and `tm`; it is blank if the rule has no period.
+func (r Rule) start(date, tm string) (string, error) {
+	if r.Period == 0 {
+		return "", nil
+	}
+	layout := "2006-01-02 15:04:05"
+	to, err := time.Parse(layout, date+" "+tm)
+	if err != nil {
+		return "", err
+	}
+	return to.Add(-r.Period - time.Duration(float64(r.Period)*periodTolerance)).Format(layout), nil
+}
+
+// Baselines returns the selection of saved valuations that contains the change rule baselines for current valuations
+// dated `date` and `tm`: valuations dated no earlier than `from` ("YYYY-MM-DD", blank if a change rule has no period)
+// that are valuations of the `names` portfolios or that hold one or more of the `symbols` assets.
+// `ok` is false if there are no change rules (no saved valuations are required).
+func (rules Rules) Baselines(date, tm string) (from string, names []string, symbols []string, ok bool) {
+	unbounded := false
+	for _, r := range rules {
+		if r.Operator != UP && r.Operator != DOWN {
+			continue
+		}
+		start, err := r.start(date, tm)
+		if err != nil {
+			return "", nil, nil, false
+		}
+		if start == "" {
+			unbounded = true
+		} else if from == "" || start[:10] < from {
+			from = start[:10]
+		}
+		if r.Metric == PRICE {
+			symbols = append(symbols, r.Subject)
+		} else {
+			names = append(names, r.Subject)
+		}
+		ok = true
+	}
+	if unbounded {
+		from = ""
+	}
+	return from, names, symbols, ok
+}
+
+// format formats a rule metric or threshold value.
+func (r Rule) format(value float64) string {
+	if r.Percent {
+		return fmt.Sprintf("%.2f%%", value)
+	}
+	return fmt.Sprintf("%.2f USD", value)
+}
+
+// ToText returns the alerts formatted as text, one alert per line.
+func (alerts Alerts) ToText() string {
+	res := ""
+	for _, a := range alerts {
+		res += fmt.Sprintf("ALERT: %s\n", a.Message)
+	}
+	return res
+}
@@ -0,0 +1,128 @@
+package alerts
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srackham/cryptor/internal/portfolio"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("btc price below $50,000")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, Rule{Text: "btc price below $50,000", Subject: "BTC", Metric: PRICE, Operator: BELOW, Threshold: 50000}, r)
+	r, err = ParseRule("aggregate value up 10% in 24h")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, Rule{Text: "aggregate value up 10% in 24h", Subject: "aggregate", Metric: VALUE, Operator: UP, Threshold: 10, Percent: true, Period: 24 * time.Hour}, r)
+	r, err = ParseRule("personal portfolio gains below 0")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, Rule{Text: "personal portfolio gains below 0", Subject: "personal", Metric: GAINS, Operator: BELOW}, r)
+	r, err = ParseRule("personal value down 5000 in 7d")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 7*24*time.Hour, r.Period)
+
+	tests := []struct {
+		text   string
+		errMsg string
+	}{
+		{"BTC price below", `invalid alert rule: "BTC price below"`},
+		{"BTC volume below 10", `invalid alert rule metric: "BTC volume below 10"`},
+		{"BTC price under 10", `invalid alert rule operator: "BTC price under 10"`},
+		{"BTC price below ten", `invalid alert rule threshold: "BTC price below ten"`},
+		{"BTC price below 10%", `alert rule percentage threshold requires up, down or gains: "BTC price below 10%"`},
+		{"BTC price up -10%", `alert rule threshold cannot be negative: "BTC price up -10%"`},
+		{"BTC price below 10 in 24h", `invalid alert rule: "BTC price below 10 in 24h"`},
+		{"BTC price up 10% in a-day", `invalid alert rule period: "BTC price up 10% in a-day"`},
+		{"my$ value below 10", `invalid alert rule portfolio name: "my$ value below 10"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseRule(tt.text)
+		assert.PassIf(t, err != nil, "%v: error expected", tt.text)
+		assert.EqualStrings(t, tt.errMsg, err.Error())
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	valuation := func(date, time string, value, cost, btcPrice float64) portfolio.Portfolio {
+		return portfolio.Portfolio{Name: "personal", Date: date, Time: time, Value: value, Cost: cost,
+			Assets: portfolio.Assets{{Symbol: "BTC", Price: btcPrice}}}
+	}
+	current := portfolio.Portfolios{valuation("2000-12-01", "12:30:00", 110000, 120000, 45000)}
+	saved := portfolio.Portfolios{
+		valuation("2000-11-29", "12:00:00", 80000, 120000, 40000),
+		valuation("2000-11-30", "12:00:00", 100000, 120000, 50000),
+		valuation("2000-12-02", "12:00:00", 10000, 120000, 50000), // Later than the current valuation
+	}
+	texts := []string{
+		"BTC price below 50000",
+		"BTC price above 50000",
+		"BTC price down 10%",
+		"personal value up 10% in 22h", // The baseline is older than 22h plus the period tolerance
+		"personal value up 10% in 24h", // The baseline is within the period tolerance
+		"personal value up 30% in 48h", // The baseline is the oldest valuation within the period
+		"personal value up 10001",
+		"personal gains below 0",
+		"personal gains above -10%",
+	}
+	rules, err := ParseRules(texts)
+	assert.PassIf(t, err == nil, "%v", err)
+	alerts, err := rules.Evaluate(current, saved, nil)
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `ALERT: BTC price 45000.00 USD is below 50000.00 USD
+ALERT: BTC price 45000.00 USD is down 10.00% since 2000-11-30 12:00:00
+ALERT: personal value 110000.00 USD is up 10.00% since 2000-11-30 12:00:00
+ALERT: personal value 110000.00 USD is up 37.50% since 2000-11-29 12:00:00
+ALERT: personal gains -10000.00 USD is below 0.00 USD
+ALERT: personal gains -8.33% is above -10.00%
+`
+	assert.EqualStrings(t, wanted, alerts.ToText())
+	assert.Equal(t, Alert{Rule: "BTC price below 50000", Message: "BTC price 45000.00 USD is below 50000.00 USD", Date: "2000-12-01", Time: "12:30:00"}, alerts[0])
+
+	// Change rules are not triggered without a baseline.
+	alerts, err = rules.Evaluate(current, nil, nil)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(alerts))
+
+	// Rules for assets that are not held are skipped with a warning.
+	rules, err = ParseRules([]string{"ETH price below 10", "BTC price below 50000"})
+	assert.PassIf(t, err == nil, "%v", err)
+	stderr := &bytes.Buffer{}
+	alerts, err = rules.Evaluate(current, saved, stderr)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1, len(alerts))
+	assert.EqualStrings(t, `WARNING: skipped alert rule: asset is not held: "ETH price below 10"`+"\n", stderr.String())
+
+	rules, err = ParseRules([]string{"joint value below 10"})
+	assert.PassIf(t, err == nil, "%v", err)
+	_, err = rules.Evaluate(current, saved, nil)
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `alert rule portfolio not found: "joint value below 10"`, err.Error())
+}
+
+func TestBaselines(t *testing.T) {
+	parse := func(texts ...string) Rules {
+		rules := Rules{}
+		for _, text := range texts {
+			r, err := ParseRule(text)
+			assert.PassIf(t, err == nil, "%v", err)
+			rules = append(rules, r)
+		}
+		return rules
+	}
+	_, _, _, ok := parse("BTC price below 50000", "personal gains above 0").Baselines("2000-12-01", "12:30:00")
+	assert.PassIf(t, !ok, "rules without change rules do not require saved valuations")
+
+	from, names, symbols, ok := parse("BTC price down 10% in 24h", "aggregate value up 10% in 7d", "BTC price below 50000").Baselines("2000-12-01", "12:30:00")
+	assert.PassIf(t, ok, "expected change rules")
+	assert.Equal(t, "2000-11-23", from) // 7 days plus the period tolerance
+	assert.Equal(t, "aggregate", strings.Join(names, ","))
+	assert.Equal(t, "BTC", strings.Join(symbols, ","))
+
+	// A change rule with no period requires all saved valuations.
+	from, _, _, ok = parse("BTC price down 10% in 24h", "personal value up 1000").Baselines("2000-12-01", "12:30:00")
+	assert.PassIf(t, ok, "expected change rules")
+	assert.Equal(t, "", from)
+}
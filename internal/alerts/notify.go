@@ -0,0 +1,107 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/srackham/cryptor/internal/config"
+	. "github.com/srackham/cryptor/internal/global"
+)
+
+// Notifier sends triggered alerts.
+type Notifier interface {
+	Notify(alerts Alerts) error
+}
+
+// Stdout is a notifier that prints alerts to stdout.
+type Stdout struct {
+	*Context
+}
+
+// Exec is a notifier that runs a command with the alerts text on its stdin.
+type Exec struct {
+	*Context
+	Command []string // Command name and arguments
+}
+
+// Webhook is a notifier that posts the alerts in JSON format to a URL.
+type Webhook struct {
+	*Context
+	URL string
+}
+
+// NewNotifier returns a new alert notifier configured by `conf`.
+func NewNotifier(ctx *Context, conf config.Notifier) (Notifier, error) {
+	switch conf.Type {
+	case "stdout":
+		return &Stdout{Context: ctx}, nil
+	case "exec":
+		command := strings.Fields(conf.Command)
+		if len(command) == 0 {
+			return nil, fmt.Errorf("missing exec notifier command")
+		}
+		return &Exec{Context: ctx, Command: command}, nil
+	case "webhook":
+		if conf.URL == "" {
+			return nil, fmt.Errorf("missing webhook notifier url")
+		}
+		return &Webhook{Context: ctx, URL: conf.URL}, nil
+	default:
+		return nil, fmt.Errorf("invalid notifier type: \"%s\"", conf.Type)
+	}
+}
+
+// NewNotifiers returns the notifiers configured by `confs`; a stdout notifier is returned if there are no configured notifiers.
+func NewNotifiers(ctx *Context, confs []config.Notifier) ([]Notifier, error) {
+	if len(confs) == 0 {
+		return []Notifier{&Stdout{Context: ctx}}, nil
+	}
+	res := []Notifier{}
+	for _, conf := range confs {
+		n, err := NewNotifier(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func (n *Stdout) Notify(alerts Alerts) error {
+	_, err := fmt.Fprint(n.Stdout, alerts.ToText())
+	return err
+}
+
+// Notify runs the command; the command's stdout and stderr are written to cryptor's stdout and stderr.
+func (n *Exec) Notify(alerts Alerts) error {
+	cmd := exec.Command(n.Command[0], n.Command[1:]...)
+	cmd.Stdin = strings.NewReader(alerts.ToText())
+	cmd.Stdout = n.Stdout
+	cmd.Stderr = n.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec notifier: \"%s\": %s", n.Command[0], err.Error())
+	}
+	return nil
+}
+
+// Notify posts a JSON object with an "alerts" array to the webhook URL.
+func (n *Webhook) Notify(alerts Alerts) error {
+	data, err := json.Marshal(struct {
+		Alerts Alerts `json:"alerts"`
+	}{alerts})
+	if err != nil {
+		return err
+	}
+	resp, err := n.HttpPost(n.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("webhook notifier: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook notifier: \"%s\": %s", n.URL, resp.Status)
+	}
+	return nil
+}
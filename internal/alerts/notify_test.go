@@ -0,0 +1,93 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/srackham/cryptor/internal/config"
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/go-utils/assert"
+)
+
+var testAlerts = Alerts{{Rule: "BTC price below 50000", Message: "BTC price 45000.00 USD is below 50000.00 USD", Date: "2000-12-01", Time: "12:30:00"}}
+
+func TestStdoutNotifier(t *testing.T) {
+	ctx := mock.NewContext()
+	notifiers, err := NewNotifiers(&ctx, nil)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1, len(notifiers))
+	err = notifiers[0].Notify(testAlerts)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.EqualStrings(t, "ALERT: BTC price 45000.00 USD is below 50000.00 USD\n", ctx.Stdout.(*bytes.Buffer).String())
+}
+
+func TestExecNotifier(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires the cat command")
+	}
+	ctx := mock.NewContext()
+	n, err := NewNotifier(&ctx, config.Notifier{Type: "exec", Command: "cat"})
+	assert.PassIf(t, err == nil, "%v", err)
+	err = n.Notify(testAlerts)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.EqualStrings(t, "ALERT: BTC price 45000.00 USD is below 50000.00 USD\n", ctx.Stdout.(*bytes.Buffer).String())
+
+	n, err = NewNotifier(&ctx, config.Notifier{Type: "exec", Command: "false"})
+	assert.PassIf(t, err == nil, "%v", err)
+	err = n.Notify(testAlerts)
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `exec notifier: "false": exit status 1`, err.Error())
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var body []byte
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	ctx := mock.NewContext()
+	n, err := NewNotifier(&ctx, config.Notifier{Type: "webhook", URL: server.URL + "/alerts"})
+	assert.PassIf(t, err == nil, "%v", err)
+	err = n.Notify(testAlerts)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.EqualStrings(t, "application/json", contentType)
+	var payload struct {
+		Alerts Alerts `json:"alerts"`
+	}
+	err = json.Unmarshal(body, &payload)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, testAlerts[0], payload.Alerts[0])
+
+	n, err = NewNotifier(&ctx, config.Notifier{Type: "webhook", URL: server.URL + "/fail"})
+	assert.PassIf(t, err == nil, "%v", err)
+	err = n.Notify(testAlerts)
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `webhook notifier: "`+server.URL+`/fail": 500 Internal Server Error`, err.Error())
+}
+
+func TestNewNotifier(t *testing.T) {
+	ctx := mock.NewContext()
+	tests := []struct {
+		conf   config.Notifier
+		errMsg string
+	}{
+		{config.Notifier{Type: "email"}, `invalid notifier type: "email"`},
+		{config.Notifier{Type: "exec"}, `missing exec notifier command`},
+		{config.Notifier{Type: "webhook"}, `missing webhook notifier url`},
+	}
+	for _, tt := range tests {
+		_, err := NewNotifier(&ctx, tt.conf)
+		assert.PassIf(t, err != nil, "%v: error expected", tt.conf)
+		assert.EqualStrings(t, tt.errMsg, err.Error())
+	}
+}
@@ -1,21 +1,25 @@
-// Binance pricereader.IPriceAPI interface implementation.
+// Binance price.PriceSource interface implementation.
 package binance
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/srackham/cryptor/internal/global"
-	"github.com/srackham/go-utils/cache"
+	"github.com/srackham/cryptor/internal/price"
 )
 
-// Cache data types.
-type Rates map[string]float64 // Key = currency symbol; value = value in USD.
-
 type TickerPrice struct {
 	Symbol string `json:"symbol"`
 	Price  string `json:"price"`
@@ -23,36 +27,38 @@ type TickerPrice struct {
 
 type PriceReader struct {
 	*Context
-	*cache.Cache[Rates]
+	*price.Cache
 }
 
-func (r *PriceReader) LoadCache() error { return nil }
-func (r *PriceReader) SaveCache() error { return nil }
-
 func NewPriceReader(ctx *Context) PriceReader {
-	data := make(Rates)
 	result := PriceReader{
-		ctx,
-		cache.New(&data),
+		Context: ctx,
+		Cache:   price.NewCache(ctx, filepath.Join(ctx.CacheDir, "binance-prices.json")),
 	}
 	return result
 }
 
-func (r *PriceReader) getPrice(symbol string) (float64, error) {
-	if symbol == "USDT" {
-		return 1.0, nil // Because "USDTUSDT" is an illegal trading pair.
-	}
-	url := PRICE_QUERY + symbol + "USDT"
+// Name returns the price source name.
+func (r *PriceReader) Name() string { return "binance" }
+
+// Intermediate quote assets used to derive the USD prices of assets that do not trade against USDT.
+var crossQuotes = []string{"BTC", "ETH", "BNB", "FDUSD"}
+
+var errInvalidPair = errors.New("invalid trading pair")
+
+// getPairPrice fetches the price of trading `pair`.
+func (r *PriceReader) getPairPrice(ctx context.Context, pair string) (float64, error) {
+	url := PRICE_QUERY + pair
 	var resp *http.Response
 	var err error
-	resp, err = r.HttpGet(url)
+	resp, err = r.HttpGetWithContext(ctx, url)
 	if err != nil {
 		return 0, fmt.Errorf("error making request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusBadRequest {
-		return 0, fmt.Errorf("invalid trading pair: %sUSDT", symbol)
+		return 0, fmt.Errorf("%w: %s", errInvalidPair, pair)
 	}
 	if resp.StatusCode != http.StatusOK {
 		return 0, fmt.Errorf("unexpected HTTP response status code: %d", resp.StatusCode)
@@ -73,14 +79,254 @@ func (r *PriceReader) getPrice(symbol string) (float64, error) {
 	return price, nil
 }
 
-func (r *PriceReader) GetCachedPrice(symbol string) (price float64, err error) {
+// getPrice returns the USD price of asset `symbol`, if `date` is not zero then the USD closing price on `date` is returned.
+// If the asset does not trade against USDT the price is derived through an intermediate quote asset
+// e.g. XYZBTC * BTCUSDT and the `route` is set to the trading pairs used.
+func (r *PriceReader) getPrice(ctx context.Context, symbol string, date time.Time) (price float64, route string, err error) {
+	pairPrice := func(pair string) (float64, error) {
+		if date.IsZero() {
+			return r.getPairPrice(ctx, pair)
+		}
+		return r.getPairClose(ctx, pair, date)
+	}
+	if symbol == "USDT" {
+		return r.getUSDTPrice(pairPrice)
+	}
+	price, err = pairPrice(symbol + "USDT")
+	if !errors.Is(err, errInvalidPair) {
+		return
+	}
+	pairs := []string{symbol + "USDT"}
+	for _, quote := range crossQuotes {
+		if quote == symbol {
+			continue
+		}
+		pair := symbol + quote
+		pairs = append(pairs, pair)
+		price, err = pairPrice(pair)
+		if errors.Is(err, errInvalidPair) {
+			continue
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		var quotePrice float64
+		if date.IsZero() {
+			quotePrice, err = r.getQuoteAssetPrice(ctx, quote)
+		} else {
+			quotePrice, err = pairPrice(quote + "USDT")
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		return price * quotePrice, pair + "*" + quote + "USDT", nil
+	}
+	return 0, "", fmt.Errorf("invalid trading pairs: %s", strings.Join(pairs, ", "))
+}
+
+// USD stablecoins whose USDT trading pairs are used to price USDT.
+var usdtReferences = []string{"USDC", "FDUSD"}
+
+// getUSDTPrice returns the USD price of USDT: the median of the inverse USDT prices of the USD stablecoin references
+// (e.g. 1 / USDCUSDT). Binance prices assets against USDT so USDT must be priced against a non-USDT reference to detect a
+// USDT depeg; the references are live trading pairs so the price is not affected by fiat exchange rate movements.
+// References that cannot be priced are skipped. If USDT cannot be priced then a warning is printed and USDT is priced at
+// 1 USD.
+func (r *PriceReader) getUSDTPrice(pairPrice func(pair string) (float64, error)) (float64, string, error) {
+	prices := []float64{}
+	routes := []string{}
+	errs := []string{}
+	for _, symbol := range usdtReferences {
+		pair := symbol + "USDT"
+		price, err := pairPrice(pair)
+		if err == nil && price <= 0 {
+			err = fmt.Errorf("invalid %s price: %v", pair, price)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		prices = append(prices, 1/price)
+		routes = append(routes, "1/"+pair)
+	}
+	if len(prices) == 0 {
+		fmt.Fprintf(r.Stderr, "WARNING: USDT: unable to price USDT against %s, using 1 USD: %s\n",
+			strings.Join(usdtReferences, ", "), strings.Join(errs, "; "))
+		return 1.0, "", nil
+	}
+	sort.Float64s(prices)
+	n := len(prices)
+	median := prices[n/2]
+	if n%2 == 0 {
+		median = (prices[n/2-1] + prices[n/2]) / 2
+	}
+	return median, strings.Join(routes, ","), nil
+}
+
+// getPairClose fetches the closing price of trading `pair` on the UTC `date` from the pair's daily klines (candlesticks).
+func (r *PriceReader) getPairClose(ctx context.Context, pair string, date time.Time) (float64, error) {
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	url := KLINES_QUERY + pair + "&startTime=" + strconv.FormatInt(start.UnixMilli(), 10)
+	resp, err := r.HttpGetWithContext(ctx, url)
+	if err != nil {
+		return 0, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return 0, fmt.Errorf("%w: %s", errInvalidPair, pair)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected HTTP response status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("error reading response: %v", err)
+	}
+	// Each kline is an array: [open time, open, high, low, close, volume, close time, ...]
+	var klines [][]any
+	err = json.Unmarshal(body, &klines)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing JSON: %v", err)
+	}
+	if len(klines) == 0 || len(klines[0]) < 5 {
+		return 0, fmt.Errorf("no %s price on %s", pair, start.Format("2006-01-02"))
+	}
+	openTime, _ := klines[0][0].(float64)
+	if int64(openTime) != start.UnixMilli() {
+		// Binance returns the next available kline if the pair was not trading on the date.
+		return 0, fmt.Errorf("no %s price on %s", pair, start.Format("2006-01-02"))
+	}
+	closePrice, ok := klines[0][4].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid %s kline: %v", pair, klines[0])
+	}
+	price, err := strconv.ParseFloat(closePrice, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error converting price to float: %v", err)
+	}
+	return price, nil
+}
+
+// getQuoteAssetPrice returns the USDT price of intermediate quote asset `symbol`.
+func (r *PriceReader) getQuoteAssetPrice(ctx context.Context, symbol string) (float64, error) {
+	if price, ok := r.Get(symbol); ok {
+		return price, nil
+	}
+	price, err := r.getPairPrice(ctx, symbol+"USDT")
+	if err != nil {
+		return 0, err
+	}
+	r.Put(symbol, price)
+	return price, nil
+}
+
+// Prefetch fetches the prices of assets `symbols` with a single multi-symbol ticker request and caches them.
+// Binance rejects the whole request if any of the symbols is not a valid USDT trading pair (e.g. assets that are priced
+// through an intermediate quote asset), in which case the prices of all trading pairs are fetched with a single request
+// instead. Prices that are not prefetched are fetched individually by GetQuote.
+func (r *PriceReader) Prefetch(ctx context.Context, symbols []string) error {
+	if r.Offline {
+		return nil
+	}
+	pairs := []string{}
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(symbol)
+		if _, ok := r.Get(symbol); ok || symbol == "USDT" {
+			continue
+		}
+		pair := symbol + "USDT"
+		if !slices.Contains(pairs, pair) {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	sort.Strings(pairs)
+	tickers, err := r.getTickers(ctx, pairs)
+	if errors.Is(err, errInvalidPair) {
+		tickers, err = r.getTickers(ctx, nil)
+	}
+	if err != nil {
+		return err
+	}
+	for _, ticker := range tickers {
+		if !slices.Contains(pairs, ticker.Symbol) {
+			continue
+		}
+		price, err := strconv.ParseFloat(ticker.Price, 64)
+		if err != nil {
+			return fmt.Errorf("error converting price to float: %v", err)
+		}
+		r.Put(strings.TrimSuffix(ticker.Symbol, "USDT"), price)
+	}
+	return nil
+}
+
+// getTickers fetches the prices of the trading `pairs` with a single request; if `pairs` is nil the prices of all
+// trading pairs are fetched.
+func (r *PriceReader) getTickers(ctx context.Context, pairs []string) ([]TickerPrice, error) {
+	query := ALL_PRICES_QUERY
+	if pairs != nil {
+		data, _ := json.Marshal(pairs)
+		query = PRICES_QUERY + url.QueryEscape(string(data))
+	}
+	resp, err := r.HttpGetWithContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil, fmt.Errorf("%w: %s", errInvalidPair, strings.Join(pairs, ", "))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP response status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+	var tickers []TickerPrice
+	err = json.Unmarshal(body, &tickers)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %v", err)
+	}
+	return tickers, nil
+}
+
+func (r *PriceReader) GetCachedPrice(ctx context.Context, symbol string) (price float64, err error) {
 	var ok bool
-	if price, ok = (*r.CacheData)[strings.ToUpper(symbol)]; !ok {
-		price, err = r.getPrice(symbol)
+	if price, ok = r.Get(symbol); !ok {
+		if r.Offline {
+			return 0.0, fmt.Errorf("offline: no cached price: %s", strings.ToUpper(symbol))
+		}
+		var route string
+		price, route, err = r.getPrice(ctx, strings.ToUpper(symbol), time.Time{})
 		if err != nil {
 			return 0.0, err
 		}
-		(*r.CacheData)[strings.ToUpper(symbol)] = price
+		r.PutRoute(symbol, price, route)
 	}
 	return
 }
+
+// GetQuote implements the price.PriceSource interface.
+func (r *PriceReader) GetQuote(ctx context.Context, symbol string) (price.Quote, error) {
+	p, err := r.GetCachedPrice(ctx, symbol)
+	if err != nil {
+		return price.Quote{}, err
+	}
+	return price.Quote{Symbol: strings.ToUpper(symbol), Price: p, Source: r.Name(), Route: r.Route(symbol), Stale: r.Offline}, nil
+}
+
+// GetHistoricalQuote implements the price.Historical interface.
+func (r *PriceReader) GetHistoricalQuote(ctx context.Context, symbol string, date time.Time) (price.Quote, error) {
+	symbol = strings.ToUpper(symbol)
+	p, route, err := r.getPrice(ctx, symbol, date)
+	if err != nil {
+		return price.Quote{}, err
+	}
+	return price.Quote{Symbol: symbol, Price: p, Source: r.Name(), Route: route}, nil
+}
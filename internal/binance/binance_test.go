@@ -1,9 +1,18 @@
 package binance
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	. "github.com/srackham/cryptor/internal/global"
 	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/cryptor/internal/price"
 	"github.com/srackham/go-utils/assert"
 )
 
@@ -11,21 +20,169 @@ func TestPrice(t *testing.T) {
 	ctx := mock.NewContext()
 	reader := NewPriceReader(&ctx)
 
-	price, err := reader.GetCachedPrice("BTC")
+	price, err := reader.GetCachedPrice(context.Background(), "BTC")
 	wanted := 100_000.0
 	assert.PassIf(t, err == nil, "%#v", err)
 	assert.Equal(t, wanted, price)
 
-	price, err = reader.GetCachedPrice("ETH")
+	price, err = reader.GetCachedPrice(context.Background(), "ETH")
 	wanted = 1000.0
 	assert.PassIf(t, err == nil, "%#v", err)
 	assert.Equal(t, wanted, price)
 
-	price, err = reader.GetCachedPrice("USDT")
+	price, err = reader.GetCachedPrice(context.Background(), "USDT")
 	wanted = 1.0
 	assert.PassIf(t, err == nil, "%#v", err)
 	assert.Equal(t, wanted, price)
 
-	_, err = reader.GetCachedPrice("INVALID_SYMBOL")
-	assert.Equal(t, "invalid trading pair: INVALID_SYMBOLUSDT", err.Error())
+	quote, err := reader.GetQuote(context.Background(), "eth")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "ETH", quote.Symbol)
+	assert.Equal(t, 1000.0, quote.Price)
+	assert.Equal(t, "binance", quote.Source)
+
+	_, err = reader.GetCachedPrice(context.Background(), "INVALID_SYMBOL")
+	assert.Equal(t, "invalid trading pairs: INVALID_SYMBOLUSDT, INVALID_SYMBOLBTC, INVALID_SYMBOLETH, INVALID_SYMBOLBNB, INVALID_SYMBOLFDUSD", err.Error())
+}
+
+func TestUSDTPrice(t *testing.T) {
+	ctx := mock.NewContext()
+	usdcPrice := "1.02" // USDCUSDT price
+	httpGet := ctx.HttpGetWithContext
+	ctx.HttpGetWithContext = func(c context.Context, url string) (*http.Response, error) {
+		if url == PRICE_QUERY+"USDCUSDT" {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"symbol":"USDCUSDT","price":"` + usdcPrice + `"}`)),
+			}, nil
+		}
+		return httpGet(c, url)
+	}
+	reader := NewPriceReader(&ctx)
+	// A USDT depeg is detected because USDT is priced against USD stablecoins (FDUSDUSDT = 1).
+	quote, err := reader.GetQuote(context.Background(), "USDT")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "0.9902", fmt.Sprintf("%.4f", quote.Price))
+	assert.Equal(t, "1/USDCUSDT,1/FDUSDUSDT", quote.Route)
+
+	quote, err = reader.GetHistoricalQuote(context.Background(), "USDT", time.Date(2000, 6, 30, 0, 0, 0, 0, time.UTC))
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.0, quote.Price)
+
+	// References that cannot be priced are skipped.
+	usdcPrice = "BAD"
+	reader = NewPriceReader(&ctx)
+	quote, err = reader.GetQuote(context.Background(), "USDT")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.0, quote.Price)
+	assert.Equal(t, "1/FDUSDUSDT", quote.Route)
+
+	// USDT is priced at 1 USD with a warning if it cannot be priced against any reference.
+	ctx.HttpGetWithContext = func(c context.Context, url string) (*http.Response, error) {
+		return nil, fmt.Errorf("network error")
+	}
+	reader = NewPriceReader(&ctx)
+	quote, err = reader.GetQuote(context.Background(), "USDT")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.0, quote.Price)
+	assert.Contains(t, ctx.Stderr.(*bytes.Buffer).String(), "WARNING: USDT: unable to price USDT against USDC, FDUSD, using 1 USD: error making request: network error")
+}
+
+func TestCrossQuotePrice(t *testing.T) {
+	ctx := mock.NewContext()
+	reader := NewPriceReader(&ctx)
+
+	quote, err := reader.GetQuote(context.Background(), "xyz")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "XYZ", quote.Symbol)
+	assert.Equal(t, 10.0, quote.Price)
+	assert.Equal(t, "XYZBTC*BTCUSDT", quote.Route)
+
+	// The intermediate quote asset price is cached.
+	price, ok := reader.Get("BTC")
+	assert.PassIf(t, ok, "missing cached BTC price")
+	assert.Equal(t, 100_000.0, price)
+
+	// Direct prices have no route.
+	quote, err = reader.GetQuote(context.Background(), "BTC")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "", quote.Route)
+}
+
+func TestPrefetch(t *testing.T) {
+	ctx := mock.NewContext()
+	urls := []string{}
+	httpGet := ctx.HttpGetWithContext
+	ctx.HttpGetWithContext = func(c context.Context, url string) (*http.Response, error) {
+		urls = append(urls, url)
+		return httpGet(c, url)
+	}
+	reader := NewPriceReader(&ctx)
+
+	err := reader.Prefetch(context.Background(), []string{"eth", "BTC", "USDT", "ETH"})
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, 1, len(urls))
+	assert.Equal(t, PRICES_QUERY+"%5B%22BTCUSDT%22%2C%22ETHUSDT%22%5D", urls[0])
+	price, err := reader.GetCachedPrice(context.Background(), "BTC")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, 100_000.0, price)
+	price, err = reader.GetCachedPrice(context.Background(), "ETH")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, 1000.0, price)
+	assert.Equal(t, 1, len(urls))
+
+	// If the multi-symbol request is rejected the prices of all trading pairs are fetched and the valid pairs are cached.
+	urls = []string{}
+	err = reader.Prefetch(context.Background(), []string{"USDC", "INVALID_SYMBOL", "BTC"})
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, 2, len(urls))
+	assert.Equal(t, PRICES_QUERY+"%5B%22INVALID_SYMBOLUSDT%22%2C%22USDCUSDT%22%5D", urls[0])
+	assert.Equal(t, ALL_PRICES_QUERY, urls[1])
+	price, err = reader.GetCachedPrice(context.Background(), "USDC")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, 1.0, price)
+	assert.Equal(t, 2, len(urls))
+	_, ok := reader.Get("EUR")
+	assert.PassIf(t, !ok, "unrequested pairs should not be cached")
+	_, err = reader.GetCachedPrice(context.Background(), "INVALID_SYMBOL")
+	assert.PassIf(t, err != nil, "invalid symbol should generate an error")
+	assert.Equal(t, PRICE_QUERY+"INVALID_SYMBOLUSDT", urls[2])
+}
+
+func TestHistoricalQuote(t *testing.T) {
+	ctx := mock.NewContext()
+	urls := []string{}
+	httpGet := ctx.HttpGetWithContext
+	ctx.HttpGetWithContext = func(c context.Context, url string) (*http.Response, error) {
+		urls = append(urls, url)
+		return httpGet(c, url)
+	}
+	reader := NewPriceReader(&ctx)
+	date := time.Date(2000, 6, 30, 0, 0, 0, 0, time.UTC)
+
+	quote, err := reader.GetHistoricalQuote(context.Background(), "btc", date)
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, price.Quote{Symbol: "BTC", Price: 50_000, Source: "binance"}, quote)
+	assert.Equal(t, KLINES_QUERY+"BTCUSDT&startTime=962323200000", urls[0])
+
+	quote, err = reader.GetHistoricalQuote(context.Background(), "XYZ", date)
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, 10.0, quote.Price)
+	assert.Equal(t, "XYZBTC*BTCUSDT", quote.Route)
+
+	// Historical prices are not cached.
+	_, ok := reader.Get("BTC")
+	assert.PassIf(t, !ok, "historical price should not be cached")
+
+	_, err = reader.GetHistoricalQuote(context.Background(), "INVALID_SYMBOL", date)
+	assert.PassIf(t, err != nil, "invalid symbol should generate an error")
+}
+
+func TestCancelledRequest(t *testing.T) {
+	ctx := mock.NewContext()
+	reader := NewPriceReader(&ctx)
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := reader.GetCachedPrice(c, "BTC")
+	assert.Equal(t, "error making request: context canceled", err.Error())
 }
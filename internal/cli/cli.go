@@ -1,38 +1,65 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"regexp"
 	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/srackham/cryptor/internal/alerts"
 	"github.com/srackham/cryptor/internal/binance"
+	"github.com/srackham/cryptor/internal/coingecko"
+	"github.com/srackham/cryptor/internal/config"
 	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/cryptor/internal/ledger"
+	"github.com/srackham/cryptor/internal/lockfile"
 	"github.com/srackham/cryptor/internal/portfolio"
+	"github.com/srackham/cryptor/internal/price"
+	"github.com/srackham/cryptor/internal/store"
 	"github.com/srackham/cryptor/internal/xrates"
 	"github.com/srackham/go-utils/fsx"
+	"github.com/srackham/go-utils/helpers"
 	"gopkg.in/yaml.v3"
 )
 
+// ErrDriftDetected is returned by the valuate command if one or more portfolio allocations are outside their drift bands.
+var ErrDriftDetected = errors.New("allocation drift detected")
+
 type cli struct {
 	*Context
-	command     string                // CLI command
-	portfolios  portfolio.Portfolios  // Crypto currency portfolios loaded from configuration file
-	valuation   portfolio.Portfolios  // Valuated portfolios
-	aggregate   portfolio.Portfolio   // Combinded portfolios valuation
-	priceReader *binance.PriceReader  // Crypto currency price oracle
-	xrates      *xrates.ExchangeRates // Fiat currency to USD exchange rate oracle
+	command     string                      // CLI command
+	portfolios  portfolio.Portfolios        // Crypto currency portfolios loaded from configuration file
+	valuation   portfolio.Portfolios        // Valuated portfolios
+	aggregate   portfolio.Portfolio         // Combinded portfolios valuation
+	config      *config.Config              // Options loaded from the config file
+	priceSource price.PriceSource           // Crypto currency price oracle
+	xrates      *xrates.ExchangeRates       // Fiat currency to USD exchange rate oracle
+	ledgers     map[string]ledger.Ledger    // Ledger portfolio transactions keyed by portfolio name
+	disposals   map[string]ledger.Disposals // Ledger portfolio disposals keyed by portfolio name
 	opts        struct {
 		aggregate     bool             // Inlcude aggregate (combined) portfolios valuation
 		aggregateOnly bool             // Only include aggregate portfolio valuation
 		currency      string           // Fiat currency symbol that the valuation is denominated in
+		date          time.Time        // Valuation date (zero for current valuations)
+		from          time.Time        // Backfill start date
+		to            time.Time        // Backfill end date
 		notes         bool             // Include portfolio notes in the valuations
+		offline       bool             // Use cached prices instead of fetching them
 		format        string           // Valuate command output format ("json" or "yaml")
 		save          bool             // Update the valuations file
 		portfolios    []string         // Names of portfolios to be printed
 		prices        portfolio.Prices // Maps asset symbols to prices
+		year          int              // Tax report year
+		minTrade      float64          // Minimum rebalancing trade value denominated in the -currency currency
+		noSell        bool             // Rebalance with cash purchases only
+		symbols       []string         // History asset symbols
 	}
 }
 
@@ -40,8 +67,6 @@ type cli struct {
 func New(ctx *Context) *cli {
 	cli := cli{}
 	cli.Context = ctx
-	priceReader := binance.NewPriceReader(ctx)
-	cli.priceReader = &priceReader
 	xrates := xrates.New(ctx)
 	xrates.CacheFile = filepath.Join(ctx.CacheDir, "exchange-rates.json")
 	cli.xrates = &xrates
@@ -52,7 +77,7 @@ func New(ctx *Context) *cli {
 func (cli *cli) Execute(args ...string) error {
 	var err error
 	defer func() {
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrDriftDetected) {
 			fmt.Fprintf(cli.Stderr, "\nERROR: %s\n", err.Error())
 		}
 	}()
@@ -67,12 +92,20 @@ func (cli *cli) Execute(args ...string) error {
 		return err
 	}
 	switch cli.command {
+	case "backfill":
+		err = cli.backfillCmd()
 	case "help":
 		cli.helpCmd()
 	case "history":
 		err = cli.historyCmd()
 	case "init":
 		err = cli.initCmd()
+	case "migrate":
+		err = cli.migrateCmd()
+	case "rebalance":
+		err = cli.rebalanceCmd()
+	case "tax-report":
+		err = cli.taxReportCmd()
 	case "valuate":
 		err = cli.valuateCmd()
 	default:
@@ -107,11 +140,16 @@ func (cli *cli) parseArgs(args []string) error {
 			cli.opts.aggregate = true
 		case opt == "-aggregate-only":
 			cli.opts.aggregateOnly = true
+		case opt == "-no-sell":
+			cli.opts.noSell = true
 		case opt == "-notes":
 			cli.opts.notes = true
+		case opt == "-offline":
+			cli.opts.offline = true
+			cli.xrates.Offline = true
 		case opt == "-save":
 			cli.opts.save = true
-		case slices.Contains([]string{"-confdir", "-currency", "-format", "-portfolio", "-price"}, opt):
+		case slices.Contains([]string{"-confdir", "-currency", "-date", "-format", "-from", "-min-trade", "-portfolio", "-price", "-symbol", "-to", "-year"}, opt):
 			// Process option argument.
 			if i+1 >= len(args) {
 				return fmt.Errorf("missing %s argument value", opt)
@@ -124,11 +162,30 @@ func (cli *cli) parseArgs(args []string) error {
 				cli.DataDir = arg
 			case "-currency":
 				cli.opts.currency = strings.ToUpper(arg)
+			case "-date", "-from", "-to":
+				date, err := time.Parse("2006-01-02", arg)
+				if err != nil {
+					return fmt.Errorf("invalid %s argument: \"%s\"", opt, arg)
+				}
+				switch opt {
+				case "-date":
+					cli.opts.date = date
+				case "-from":
+					cli.opts.from = date
+				case "-to":
+					cli.opts.to = date
+				}
 			case "-format":
-				if !slices.Contains([]string{"json", "yaml"}, arg) {
+				if !slices.Contains([]string{"csv", "json", "yaml"}, arg) {
 					return fmt.Errorf("invalid -format argument: \"%s\"", arg)
 				}
 				cli.opts.format = arg
+			case "-min-trade":
+				value, err := strconv.ParseFloat(arg, 64)
+				if err != nil || value < 0 {
+					return fmt.Errorf("invalid -min-trade argument: \"%s\"", arg)
+				}
+				cli.opts.minTrade = value
 			case "-portfolio":
 				if !portfolio.IsValidName(arg) {
 					return fmt.Errorf("invalid -portfolio argument: \"%s\"", arg)
@@ -137,12 +194,24 @@ func (cli *cli) parseArgs(args []string) error {
 					return fmt.Errorf("-portfolio name can only be specified once: \"%s\"", arg)
 				}
 				cli.opts.portfolios = slices.Insert(cli.opts.portfolios, len(cli.opts.portfolios), arg)
+			case "-symbol":
+				symbol := strings.ToUpper(arg)
+				if !regexp.MustCompile(`^[A-Z0-9]+$`).MatchString(symbol) {
+					return fmt.Errorf("invalid -symbol argument: \"%s\"", arg)
+				}
+				cli.opts.symbols = append(cli.opts.symbols, symbol)
 			case "-price":
 				symbol, price, err := ParsePriceOption(arg)
 				if err != nil {
 					return err
 				}
 				cli.opts.prices[symbol] = price
+			case "-year":
+				year, err := strconv.Atoi(arg)
+				if err != nil || len(arg) != 4 {
+					return fmt.Errorf("invalid -year argument: \"%s\"", arg)
+				}
+				cli.opts.year = year
 			default:
 				return fmt.Errorf("unexpected option: \"%s\"", opt)
 			}
@@ -151,6 +220,9 @@ func (cli *cli) parseArgs(args []string) error {
 			return fmt.Errorf("invalid argument: \"%s\"", opt)
 		}
 	}
+	if cli.opts.format == "csv" && cli.command != "tax-report" {
+		return fmt.Errorf("-format csv is only valid for the tax-report command")
+	}
 	return nil
 }
 
@@ -186,9 +258,11 @@ func (cli *cli) initCmd() error {
 		fmt.Fprintf(cli.Stdout, "config file already exists: \"%s\"\n", cli.configFile())
 	} else {
 		fmt.Fprintf(cli.Stdout, "installing example config file: \"%s\"\n", cli.configFile())
-		contents := `# NOTE: The xrates-appid option is only necessary if the -currency command option is used.
-# Open Exchange Rates App ID (https://openexchangerates.org/)
-xrates-appid: YOUR_APP_ID`
+		contents := `# Fiat currency exchange rates provider used by the -currency command option:
+# frankfurter (the default), ecb or openexchangerates.
+# xrates-provider: frankfurter
+# Open Exchange Rates App ID (https://openexchangerates.org/), only necessary for the openexchangerates provider.
+# xrates-appid: YOUR_APP_ID`
 		if err := fsx.WriteFile(cli.configFile(), contents); err != nil {
 			return fmt.Errorf("failed to write config file: \"%s\"", err.Error())
 		}
@@ -234,20 +308,45 @@ xrates-appid: YOUR_APP_ID`
 }
 
 // historyCmd prints the saved valuations history.
+// If a non-USD -currency option is specified the valuations are printed in text format.
 func (cli *cli) historyCmd() (err error) {
-	fname := cli.valuationsFile("json")
-	valuations := portfolio.Portfolios{}
-	if fsx.FileExists(fname) {
-		valuations, err = portfolio.LoadValuations(fname)
-		if err != nil {
-			return fmt.Errorf("valuations file: \"%s\": %s", fname, err.Error())
-		}
+	if err := cli.loadConfig(); err != nil {
+		return err
 	}
-	if len(cli.opts.portfolios) > 0 {
-		valuations = valuations.FilterByName(cli.opts.portfolios...)
+	vs, err := cli.valuationStore()
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	query := store.Query{Names: cli.opts.portfolios, Symbols: cli.opts.symbols}
+	if !cli.opts.from.IsZero() {
+		query.From = cli.opts.from.Format("2006-01-02")
+	}
+	if !cli.opts.to.IsZero() {
+		query.To = cli.opts.to.Format("2006-01-02")
+	}
+	valuations := portfolio.Portfolios{}
+	err = vs.Query(query, func(p portfolio.Portfolio) error {
+		valuations = append(valuations, p)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
 	}
 	if len(valuations) == 0 {
-		return fmt.Errorf("valuations file: \"%s\": no valuations found", fname)
+		return fmt.Errorf("valuations file: \"%s\": no valuations found", vs.Name())
+	}
+	if cli.opts.currency != "USD" && cli.opts.format == "" {
+		// Print text formatted valuations converted at the exchange rates on each valuation date.
+		for _, v := range valuations {
+			rate, err := cli.xrates.GetRateOn(cli.opts.currency, v.Date)
+			if err != nil {
+				return err
+			}
+			s, _ := portfolio.Portfolios{v}.ToString("", cli.opts.currency, rate, cli.xrates.RateDate(v.Date))
+			fmt.Fprintf(cli.Stdout, "\n%s\n", s)
+		}
+		return cli.saveCaches()
 	}
 	var s string
 	if cli.opts.format == "yaml" {
@@ -272,22 +371,34 @@ Description:
     Cryptor valuates crypto currency asset portfolios.
 
 Commands:
-    init     create configuration directory and install default config and
-             example portfolios files
-    valuate  valuate, print and save portfolio valuations
-    history  Print saved portfolio valuations
-    help     display documentation
+    init       create configuration directory and install default config and
+               example portfolios files
+    valuate    valuate, print and save portfolio valuations
+    history    Print saved portfolio valuations
+    backfill   save historical valuations for days with no saved valuations
+    tax-report print ledger disposals capital gains for a tax year
+    rebalance  print the trades that rebalance portfolios to their target allocations
+    migrate    convert the valuations JSON file to the configured valuations store
+    help       display documentation
 
 Options:
     -aggregate                  Include aggregated portfolios in printed valuation
     -aggregate-only             Only include aggregated portfolios in printed valuation
     -confdir CONF_DIR           Directory containing config, data and cache files
     -currency CURRENCY          Print fiat currency values denominated in CURRENCY
+    -date DATE                  Valuate portfolios at closing prices on DATE (YYYY-MM-DD)
     -notes                      Include portfolio notes in the valuations
+    -offline                    Valuate using cached prices and exchange rates (valuations are labeled stale)
     -save                       Update the valuations file
     -portfolio PORTFOLIO        Print named portfolio valuation (default: all portfolios)
     -price SYMBOL=PRICE         Override the asset price of SYMBOL with PRICE (in USD)
-    -format FORMAT              Set the valuate command output format ("json" or "yaml")
+    -format FORMAT              Set the command output format ("json", "yaml" or "csv" (tax-report only))
+    -from DATE                  Backfill and history start date (YYYY-MM-DD)
+    -to DATE                    Backfill and history end date (YYYY-MM-DD, backfill default: yesterday)
+    -symbol SYMBOL              Print history valuations holding asset SYMBOL
+    -year YEAR                  Tax report year (YYYY)
+    -min-trade VALUE            Omit rebalancing trades valued less than VALUE (in -currency)
+    -no-sell                    Rebalance with cash purchases only
 
 Config directory: ` + cli.ConfigDir + `
 Cache directory:  ` + cli.CacheDir + `
@@ -302,7 +413,7 @@ Github:     ` + github
 }
 
 func isCommand(name string) bool {
-	return slices.Contains([]string{"help", "history", "init", "valuate"}, name)
+	return slices.Contains([]string{"backfill", "help", "history", "init", "migrate", "rebalance", "tax-report", "valuate"}, name)
 }
 
 func (cli *cli) configFile() string {
@@ -317,6 +428,173 @@ func (cli *cli) valuationsFile(format string) string {
 	return filepath.Join(cli.DataDir, "valuations."+format)
 }
 
+// storeFormat returns the configured valuations store format; if it is not configured the JSON Lines format is used
+// if the JSON Lines valuations file exists (see the migrate command), otherwise the JSON format is used.
+func (cli *cli) storeFormat() string {
+	if cli.config.ValuationsStore != "" {
+		return cli.config.ValuationsStore
+	}
+	if fsx.FileExists(store.Filename(cli.DataDir, store.JSONL)) {
+		return store.JSONL
+	}
+	return store.JSON
+}
+
+// valuationStore returns the saved valuations store.
+func (cli *cli) valuationStore() (store.ValuationStore, error) {
+	format := cli.storeFormat()
+	return store.New(cli.Context, format, store.Filename(cli.DataDir, format))
+}
+
+// loadConfig reads the optional config file; if the file does not exist default options are used.
+func (cli *cli) loadConfig() error {
+	cli.config = &config.Config{}
+	if fsx.FileExists(cli.configFile()) {
+		conf, err := config.LoadConfig(cli.configFile())
+		if err != nil {
+			return err
+		}
+		cli.config = conf
+	}
+	if cli.config.ValuationsStore != "" && !store.IsFormat(cli.config.ValuationsStore) {
+		return fmt.Errorf("invalid valuations-store: \"%s\"", cli.config.ValuationsStore)
+	}
+	if cli.config.XratesMaxAge != nil {
+		if *cli.config.XratesMaxAge < 0 {
+			return fmt.Errorf("invalid xrates-max-age: %d", *cli.config.XratesMaxAge)
+		}
+		cli.xrates.MaxAge = *cli.config.XratesMaxAge
+	}
+	return nil
+}
+
+// newNamedPriceSource returns a new crypto currency price source.
+// If the -date option was specified the price source returns historical prices.
+func (cli *cli) newNamedPriceSource(name string) (price.PriceSource, error) {
+	var source price.PriceSource
+	switch name {
+	case "binance":
+		reader := binance.NewPriceReader(cli.Context)
+		reader.TTL = cli.config.PriceCacheTTL
+		reader.Offline = cli.opts.offline
+		source = &reader
+	case "coingecko":
+		reader := coingecko.NewPriceReader(cli.Context, cli.config.CoingeckoIds)
+		reader.TTL = cli.config.PriceCacheTTL
+		reader.Offline = cli.opts.offline
+		source = &reader
+	default:
+		return nil, fmt.Errorf("invalid price source: \"%s\"", name)
+	}
+	if !cli.opts.date.IsZero() {
+		source = price.NewOnDate(source, cli.opts.date)
+	}
+	return source, nil
+}
+
+// getRate returns the amount of fiat `currency` that $1 USD would buy at the valuation date's exchange rates.
+func (cli *cli) getRate(currency string) (float64, error) {
+	if cli.opts.date.IsZero() {
+		return cli.xrates.GetCachedRate(currency, false)
+	}
+	return cli.xrates.GetRateOn(currency, cli.opts.date.Format("2006-01-02"))
+}
+
+// getUSDValue returns the USD value of one unit of fiat `currency` at the valuation date's exchange rates.
+func (cli *cli) getUSDValue(currency string) (float64, error) {
+	xrate, err := cli.getRate(currency)
+	if err != nil {
+		return 0, err
+	}
+	return 1 / xrate, nil
+}
+
+// newPriceSource returns the crypto currency price source configured in the config file.
+// Stablecoins are priced by a stablecoin price source that wraps the market price source.
+func (cli *cli) newPriceSource() (price.PriceSource, error) {
+	source, err := cli.newMarketPriceSource()
+	if err != nil {
+		return nil, err
+	}
+	pegs := make(map[string]string)
+	for k, v := range price.DefaultPegs {
+		pegs[k] = v
+	}
+	for k, v := range cli.config.Stablecoins {
+		pegs[strings.ToUpper(k)] = v
+	}
+	threshold := 0.5
+	if cli.config.DepegThreshold != nil {
+		threshold = *cli.config.DepegThreshold
+	}
+	pegged := price.NewPegged(cli.Context, source, pegs, cli.getUSDValue, threshold)
+	switch cli.config.StablecoinMode {
+	case "", "market":
+	case "peg":
+		pegged.UsePeg = true
+	default:
+		return nil, fmt.Errorf("invalid stablecoin-mode: \"%s\"", cli.config.StablecoinMode)
+	}
+	return pegged, nil
+}
+
+// newMarketPriceSource returns the crypto currency market price source configured in the config file.
+// If more than one price source is configured, or assets are pinned to price sources, a fallback chain of price sources is returned.
+// In consensus price mode the median price of all configured price sources is used.
+func (cli *cli) newMarketPriceSource() (price.PriceSource, error) {
+	names := cli.config.PriceSources
+	if len(names) == 0 {
+		names = []string{helpers.If(cli.config.PriceSource == "", "binance", cli.config.PriceSource)}
+	}
+	sources := make(map[string]price.PriceSource) // Price source instances share caches
+	get := func(name string) (price.PriceSource, error) {
+		name = strings.ToLower(name)
+		if source, ok := sources[name]; ok {
+			return source, nil
+		}
+		source, err := cli.newNamedPriceSource(name)
+		if err == nil {
+			sources[name] = source
+		}
+		return source, err
+	}
+	chain := []price.PriceSource{}
+	for _, name := range names {
+		source, err := get(name)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, source)
+	}
+	pinned := make(map[string]price.PriceSource)
+	for symbol, name := range cli.config.AssetSources {
+		source, err := get(name)
+		if err != nil {
+			return nil, err
+		}
+		pinned[symbol] = source
+	}
+	switch cli.config.PriceMode {
+	case "", "fallback":
+		if len(chain) == 1 && len(pinned) == 0 {
+			return chain[0], nil
+		}
+		return price.NewChain(chain, pinned), nil
+	case "consensus":
+		tolerance := 2.0
+		if cli.config.PriceTolerance != nil {
+			tolerance = *cli.config.PriceTolerance
+		}
+		consensus := price.NewConsensus(cli.Context, chain, tolerance)
+		if len(pinned) == 0 {
+			return consensus, nil
+		}
+		return price.NewChain([]price.PriceSource{consensus}, pinned), nil
+	default:
+		return nil, fmt.Errorf("invalid price-mode: \"%s\"", cli.config.PriceMode)
+	}
+}
+
 func (cli *cli) loadPortfolios() (err error) {
 	ps, err := cli.loadConfigFile(cli.portfoliosFile())
 	if err != nil {
@@ -329,22 +607,33 @@ func (cli *cli) loadPortfolios() (err error) {
 	return nil
 }
 
-// save appends the current valuation to the valuations file and saves the exchange rates cache file.
+// save appends the current valuation to the valuations file and saves the prices and exchange rates cache files.
+// The valuations file is locked while it is written so that concurrent cryptor processes cannot interleave their updates.
 func (cli *cli) save() (err error) {
 	if cli.opts.save {
-		fname := cli.valuationsFile("json")
-		valuations := portfolio.Portfolios{}
-		if fsx.FileExists(fname) {
-			valuations, err = portfolio.LoadValuations(fname)
-			if err != nil {
-				return fmt.Errorf("valuations file: \"%s\": %s", fname, err.Error())
-			}
+		vs, err := cli.valuationStore()
+		if err != nil {
+			return err
 		}
-		valuations = append(valuations, cli.valuation...)
-		valuations = append(valuations, cli.aggregate)
-		err = valuations.SaveValuations(fname)
+		defer vs.Close()
+		l, err := lockfile.New(vs.Name())
 		if err != nil {
-			return fmt.Errorf("valuations file: \"%s\": %s", fname, err.Error())
+			return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
+		}
+		defer l.Unlock()
+		err = vs.Append(append(slices.Clone(cli.valuation), cli.aggregate))
+		if err != nil {
+			return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
+		}
+	}
+	return cli.saveCaches()
+}
+
+// saveCaches saves the prices and exchange rates cache files.
+func (cli *cli) saveCaches() (err error) {
+	if cli.priceSource != nil {
+		if err = price.SaveCache(cli.priceSource); err != nil {
+			return fmt.Errorf("prices cache: %s", err.Error())
 		}
 	}
 	if len(*(cli.xrates.CacheData)) > 0 {
@@ -356,30 +645,98 @@ func (cli *cli) save() (err error) {
 	return
 }
 
+// fetchQuotes concurrently fetches the prices of assets `symbols` from the configured price source.
+// Outstanding price requests are cancelled when the price-timeout deadline expires.
+func (cli *cli) fetchQuotes(symbols []string) (price.Quotes, error) {
+	timeout := helpers.If(cli.config.PriceTimeout > 0, cli.config.PriceTimeout, 60*time.Second)
+	workers := helpers.If(cli.config.PriceWorkers > 0, cli.config.PriceWorkers, 4)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Fetch the prices of all assets in one go, unfetched prices are fetched individually.
+	_ = price.Prefetch(ctx, cli.priceSource, symbols)
+	return price.FetchQuotes(ctx, cli.priceSource, symbols, workers)
+}
+
+// valuate prices and valuates portfolios `ps` using the current price source and returns their aggregate valuation.
+// The valuations are dated `date` and timed `time`.
+func (cli *cli) valuate(ps portfolio.Portfolios, date string, time string) (portfolio.Portfolio, error) {
+	quotes, err := cli.fetchQuotes(ps.UnpricedSymbols())
+	if err != nil {
+		return portfolio.Portfolio{}, err
+	}
+	for i := range ps {
+		ps[i].Date = date
+		ps[i].Time = time
+		if err := ps[i].SetUSDValues(context.Background(), quotes); err != nil {
+			return portfolio.Portfolio{}, err
+		}
+		ps[i].SetAllocations()
+		ps[i].SetGains()
+		ps[i].Assets.Sort()
+		if ps[i].CostDate != "" && ps[i].Cost > 0.00 {
+			// The FX gain is the cost's USD value at the cost date less its USD value at the valuation date.
+			usd, err := cli.currencyToUSD(ps[i].CostAmount, ps[i].CostCurrency, "")
+			if err != nil {
+				return portfolio.Portfolio{}, err
+			}
+			ps[i].FXGains = ps[i].Cost - usd
+		}
+	}
+	aggregate := ps.Aggregate("aggregate")
+	aggregate.Date = date
+	aggregate.Time = time
+	return aggregate, nil
+}
+
 // valuateCmd implements the valuate command.
+// If the -date option was specified portfolios are valuated at the closing prices and exchange rates on that date.
 func (cli *cli) valuateCmd() error {
 	now := cli.Now()
 	date := now.Format("2006-01-02")
 	time := now.Format("15:04:05")
+	if !cli.opts.date.IsZero() {
+		if cli.opts.date.Format("2006-01-02") >= date {
+			return fmt.Errorf("-date must be earlier than today: \"%s\"", cli.opts.date.Format("2006-01-02"))
+		}
+		if cli.opts.offline {
+			return fmt.Errorf("the -date and -offline options cannot be combined")
+		}
+		date = cli.opts.date.Format("2006-01-02")
+		time = "23:59:59"
+	}
+	if err := cli.loadConfig(); err != nil {
+		return err
+	}
 	if err := cli.loadPortfolios(); err != nil {
 		return err
 	}
+	rules, err := alerts.ParseRules(cli.config.Alerts.Rules)
+	if err != nil {
+		return err
+	}
+	notifiers, err := alerts.NewNotifiers(cli.Context, cli.config.Alerts.Notifiers)
+	if err != nil {
+		return err
+	}
+	if !cli.opts.date.IsZero() {
+		// Alerts are not evaluated for historical valuations (replayed past prices must not trigger notifications).
+		rules = nil
+	}
+	source, err := cli.newPriceSource()
+	if err != nil {
+		return err
+	}
+	cli.priceSource = source
+	if err := price.LoadCache(cli.priceSource); err != nil {
+		return fmt.Errorf("prices cache: %s", err.Error())
+	}
 	// Select portfolios to be valuated.
 	cli.valuation = portfolio.Portfolios{}
 	cli.valuation = cli.portfolios
-	// Evaluate portfolios.
-	for i := range cli.valuation {
-		cli.valuation[i].Date = date
-		cli.valuation[i].Time = time
-		if err := cli.valuation[i].SetUSDValues(cli.priceReader); err != nil {
-			return err
-		}
-		cli.valuation[i].SetAllocations()
-		cli.valuation[i].Assets.Sort()
+	cli.aggregate, err = cli.valuate(cli.valuation, date, time)
+	if err != nil {
+		return err
 	}
-	cli.aggregate = cli.valuation.Aggregate("aggregate")
-	cli.aggregate.Date = date
-	cli.aggregate.Time = time
 	printed_valuation := cli.valuation
 	if len(cli.opts.portfolios) > 0 {
 		// Select -portfolio option valuations.
@@ -396,29 +753,459 @@ func (cli *cli) valuateCmd() error {
 		printed_valuation = append(printed_valuation, cli.aggregate)
 	}
 	// Print portfolios.
-	xrate, err := cli.xrates.GetCachedRate(cli.opts.currency, false)
+	xrate, err := cli.getRate(cli.opts.currency)
 	if err != nil {
 		return err
 	}
-	if s, err := printed_valuation.ToString(cli.opts.format, cli.opts.currency, xrate); err != nil {
+	if s, err := printed_valuation.ToString(cli.opts.format, cli.opts.currency, xrate, cli.xrates.RateDate(date)); err != nil {
 		return err
 	} else {
 		fmt.Fprintf(cli.Stdout, "\n%s\n", s)
 	}
+	// Load the alert baseline valuations before the current valuation is saved.
+	saved := portfolio.Portfolios{}
+	if from, names, symbols, ok := rules.Baselines(cli.aggregate.Date, cli.aggregate.Time); ok {
+		vs, err := cli.valuationStore()
+		if err != nil {
+			return err
+		}
+		defer vs.Close()
+		// Query fields are combined with a logical AND so mixed portfolio and price rules are selected by the callback.
+		query := store.Query{From: from}
+		if len(symbols) == 0 {
+			query.Names = names
+		} else if len(names) == 0 {
+			query.Symbols = symbols
+		}
+		err = vs.Query(query, func(p portfolio.Portfolio) error {
+			if slices.Contains(names, p.Name) || slices.ContainsFunc(symbols, func(sym string) bool { return p.Assets.Find(sym) != -1 }) {
+				saved = append(saved, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
+		}
+	}
 	// Save valuation and cache files.
 	if err := cli.save(); err != nil {
 		return err
 	}
+	// Report the target allocation drift of all portfolios (drift is reported before alerts are sent so that alert
+	// errors do not prevent it being reported).
+	drifted := false
+	for _, p := range cli.valuation {
+		for _, d := range p.Drifts() {
+			fmt.Fprintf(cli.Stderr, "WARNING: %s\n", d)
+			drifted = true
+		}
+	}
+	// Send triggered alerts.
+	err = cli.sendAlerts(rules, notifiers, saved)
+	if drifted {
+		if err != nil {
+			// Alert errors are reported without hiding the drift detected error (and exit status).
+			fmt.Fprintf(cli.Stderr, "\nERROR: %s\n", err.Error())
+		}
+		return ErrDriftDetected
+	}
+	return err
+}
+
+// sendAlerts evaluates the alert `rules` against the current valuations and the `saved` baseline valuations and sends
+// the triggered alerts to the `notifiers`.
+func (cli *cli) sendAlerts(rules alerts.Rules, notifiers []alerts.Notifier, saved portfolio.Portfolios) error {
+	triggered, err := rules.Evaluate(append(portfolio.Portfolios{cli.aggregate}, cli.valuation...), saved, cli.Stderr)
+	if err != nil {
+		return err
+	}
+	if len(triggered) > 0 {
+		for _, n := range notifiers {
+			if err := n.Notify(triggered); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backfillCmd implements the backfill command.
+// Valuations are synthesized at historical prices for days from the -from date to the -to date (inclusive) that have no saved valuations.
+// Ledger portfolio holdings are derived from the ledger entries up to and including the day; other portfolio holdings are
+// taken from the portfolio's most recent earlier saved valuation. Portfolios with no holdings history for the day (no
+// earlier ledger entries or saved valuations) are skipped with a warning, as are days with no portfolio holdings history.
+func (cli *cli) backfillCmd() (err error) {
+	if cli.opts.from.IsZero() {
+		return fmt.Errorf("missing -from option")
+	}
+	if cli.opts.offline {
+		return fmt.Errorf("the backfill command cannot be used with the -offline option")
+	}
+	today := cli.Now().Format("2006-01-02")
+	to := cli.opts.to
+	if to.IsZero() {
+		to, _ = time.Parse("2006-01-02", today)
+		to = to.AddDate(0, 0, -1)
+	}
+	if to.Format("2006-01-02") >= today {
+		return fmt.Errorf("-to date must be earlier than today: \"%s\"", to.Format("2006-01-02"))
+	}
+	if cli.opts.from.After(to) {
+		return fmt.Errorf("-from date is after -to date: \"%s\"", cli.opts.from.Format("2006-01-02"))
+	}
+	if err := cli.loadConfig(); err != nil {
+		return err
+	}
+	if err := cli.loadPortfolios(); err != nil {
+		return err
+	}
+	vs, err := cli.valuationStore()
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	valuations, err := vs.Load()
+	if err != nil {
+		return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
+	}
+	synthesized := portfolio.Portfolios{}
+	skipped := 0 // Number of days with no portfolio holdings history
+	for d := cli.opts.from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		if len(valuations.FilterByDate(date)) > 0 {
+			continue
+		}
+		// Reconstruct the portfolio holdings that applied on the day; portfolios with no holdings history for the day are skipped.
+		ps := portfolio.Portfolios{}
+		for _, p := range cli.portfolios {
+			holdings := portfolio.Portfolio{Name: p.Name, Notes: p.Notes, Assets: portfolio.Assets{}}
+			if l, ok := cli.ledgers[p.Name]; ok {
+				// Ledger portfolio holdings are derived from the ledger entries up to and including the day.
+				if l.Sorted()[0].Date > date {
+					fmt.Fprintf(cli.Stderr, "WARNING: %s: portfolio has no ledger entries: \"%s\"\n", date, p.Name)
+					continue
+				}
+				if _, err := cli.ledgerHoldings(&holdings, l, date); err != nil {
+					return err
+				}
+				ps = append(ps, holdings)
+				continue
+			}
+			i := valuations.FindLatestBefore(p.Name, date)
+			if i == -1 {
+				fmt.Fprintf(cli.Stderr, "WARNING: %s: portfolio has no earlier saved valuations: \"%s\"\n", date, p.Name)
+				continue
+			}
+			v := valuations[i]
+			holdings.Cost, holdings.CostAmount, holdings.CostCurrency, holdings.CostDate = v.Cost, v.CostAmount, v.CostCurrency, v.CostDate
+			for _, a := range v.Assets {
+				holdings.Assets = append(holdings.Assets, portfolio.Asset{Symbol: a.Symbol, Amount: a.Amount})
+			}
+			ps = append(ps, holdings)
+		}
+		if len(ps) == 0 {
+			skipped++
+			continue
+		}
+		cli.opts.date = d
+		if cli.priceSource, err = cli.newPriceSource(); err != nil {
+			return err
+		}
+		aggregate, err := cli.valuate(ps, date, "23:59:59")
+		if err != nil {
+			return fmt.Errorf("%s: %s", date, err.Error())
+		}
+		ps = append(ps, aggregate)
+		for i := range ps {
+			ps[i].Synthesized = true
+		}
+		synthesized = append(synthesized, ps...)
+		fmt.Fprintf(cli.Stdout, "backfilled valuations: %s\n", date)
+	}
+	if len(synthesized) == 0 {
+		if skipped == 0 {
+			fmt.Fprintf(cli.Stdout, "no missing valuations\n")
+		}
+		return nil
+	}
+	// The valuations are reloaded with the valuations file locked in case they were updated while backfilling.
+	l, err := lockfile.New(vs.Name())
+	if err != nil {
+		return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
+	}
+	defer l.Unlock()
+	if valuations, err = vs.Load(); err != nil {
+		return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
+	}
+	for _, p := range synthesized {
+		if valuations.FindByNameAndDate(p.Name, p.Date) == -1 {
+			valuations = append(valuations, p)
+		}
+	}
+	sort.SliceStable(valuations, func(i, j int) bool {
+		return valuations[i].Date+valuations[i].Time < valuations[j].Date+valuations[j].Time
+	})
+	if err = vs.Save(valuations); err != nil {
+		return fmt.Errorf("valuations file: \"%s\": %s", vs.Name(), err.Error())
+	}
+	return cli.saveCaches()
+}
+
+// migrateCmd implements the migrate command.
+// The valuations JSON file is converted to the configured valuations store (defaults to a JSON Lines file) and is then
+// renamed with a .bak extension. If there is no valuations JSON file an empty store is created.
+func (cli *cli) migrateCmd() (err error) {
+	if err := cli.loadConfig(); err != nil {
+		return err
+	}
+	format := helpers.If(cli.config.ValuationsStore == "", store.JSONL, cli.config.ValuationsStore)
+	if format == store.JSON {
+		return fmt.Errorf("the migrate command cannot migrate to the json valuations store")
+	}
+	src := store.Filename(cli.DataDir, store.JSON)
+	dst := store.Filename(cli.DataDir, format)
+	if fsx.FileExists(dst) {
+		return fmt.Errorf("valuations file already exists: \"%s\"", dst)
+	}
+	if err := fsx.MkMissingDir(cli.DataDir); err != nil {
+		return err
+	}
+	l, err := lockfile.New(src)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	// The valuations are written to a temporary store that is renamed once the migration is complete.
+	ext := filepath.Ext(dst)
+	tmp := strings.TrimSuffix(dst, ext) + ".tmp" + ext
+	os.Remove(tmp)
+	vs, err := store.New(cli.Context, format, tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := vs.Close(); err == nil {
+			err = closeErr
+		}
+		os.Remove(tmp + ".bak")
+		if err == nil {
+			err = os.Rename(tmp, dst)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return
+		}
+		if fsx.FileExists(src) {
+			if err = os.Rename(src, src+".bak"); err == nil {
+				fmt.Fprintf(cli.Stdout, "renamed valuations file: \"%s\"\n", src+".bak")
+			}
+		}
+	}()
+	if !fsx.FileExists(src) {
+		fmt.Fprintf(cli.Stdout, "created valuations file: \"%s\"\n", dst)
+		return vs.Save(portfolio.Portfolios{})
+	}
+	// Migrate the valuations in batches.
+	count := 0
+	batch := portfolio.Portfolios{}
+	err = portfolio.ReadValuations(src, cli.Stderr, func(p portfolio.Portfolio) error {
+		count++
+		batch = append(batch, p)
+		if len(batch) < 1000 {
+			return nil
+		}
+		err := vs.Append(batch)
+		batch = portfolio.Portfolios{}
+		return err
+	})
+	if err == nil {
+		err = vs.Append(batch)
+	}
+	if err != nil {
+		return fmt.Errorf("valuations file: \"%s\": %s", src, err.Error())
+	}
+	fmt.Fprintf(cli.Stdout, "migrated %d valuations to: \"%s\"\n", count, dst)
 	return nil
 }
 
+// rebalanceCmd implements the rebalance command.
+// The rebalancing plans of portfolios with target allocations are printed (use the -portfolio option to select portfolios).
+func (cli *cli) rebalanceCmd() error {
+	if err := cli.loadConfig(); err != nil {
+		return err
+	}
+	if err := cli.loadPortfolios(); err != nil {
+		return err
+	}
+	ps := portfolio.Portfolios{}
+	for _, p := range cli.portfolios {
+		selected := slices.Contains(cli.opts.portfolios, p.Name)
+		if len(cli.opts.portfolios) > 0 && !selected {
+			continue
+		}
+		if len(p.Targets) == 0 {
+			if selected {
+				return fmt.Errorf("portfolio has no targets: \"%s\"", p.Name)
+			}
+			continue
+		}
+		// Add unheld targeted assets so that they are priced.
+		p = p.DeepCopy()
+		for _, t := range p.Targets {
+			for _, symbol := range t.Symbols {
+				if p.Assets.Find(symbol) == -1 {
+					p.Assets = append(p.Assets, portfolio.Asset{Symbol: symbol})
+				}
+			}
+		}
+		ps = append(ps, p)
+	}
+	if len(ps) == 0 {
+		return fmt.Errorf("no portfolios have targets")
+	}
+	source, err := cli.newPriceSource()
+	if err != nil {
+		return err
+	}
+	cli.priceSource = source
+	if err := price.LoadCache(cli.priceSource); err != nil {
+		return fmt.Errorf("prices cache: %s", err.Error())
+	}
+	now := cli.Now()
+	if _, err := cli.valuate(ps, now.Format("2006-01-02"), now.Format("15:04:05")); err != nil {
+		return err
+	}
+	xrate, err := cli.getRate(cli.opts.currency)
+	if err != nil {
+		return err
+	}
+	plans := portfolio.Rebalances{}
+	for _, p := range ps {
+		plan, err := p.Rebalance(cli.opts.minTrade/xrate, cli.opts.noSell)
+		if err != nil {
+			return fmt.Errorf("portfolio \"%s\": %s", p.Name, err.Error())
+		}
+		plans = append(plans, plan)
+	}
+	var s string
+	switch cli.opts.format {
+	case "json":
+		s, err = plans.ToJSON()
+	case "yaml":
+		s, err = plans.ToYAML()
+	default:
+		s = "\n" + plans.ToText(cli.opts.currency, xrate)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(cli.Stdout, s)
+	return cli.saveCaches()
+}
+
+// taxReportCmd implements the tax-report command.
+// The report lists the ledger portfolio disposals during the -year year in the -currency currency.
+// The average lot method is not supported because pooled disposals have no acquisition dates.
+func (cli *cli) taxReportCmd() error {
+	if cli.opts.year == 0 {
+		return fmt.Errorf("missing -year option")
+	}
+	if err := cli.loadConfig(); err != nil {
+		return err
+	}
+	if cli.lotMethod() == ledger.AVERAGE {
+		return fmt.Errorf("the tax-report command does not support lot-method: \"%s\"", ledger.AVERAGE)
+	}
+	if err := cli.loadPortfolios(); err != nil {
+		return err
+	}
+	longTermDays := cli.config.LongTermDays
+	if longTermDays == 0 {
+		longTermDays = 365
+	}
+	report := ledger.TaxReport{}
+	for _, p := range cli.portfolios {
+		if len(cli.opts.portfolios) > 0 && !slices.Contains(cli.opts.portfolios, p.Name) {
+			continue
+		}
+		events, err := ledger.TaxEvents(p.Name, cli.disposals[p.Name], cli.opts.year, longTermDays, cli.opts.currency, cli.xrates.GetRateOn)
+		if err != nil {
+			return fmt.Errorf("portfolio \"%s\": %s", p.Name, err.Error())
+		}
+		report = append(report, events...)
+	}
+	report.Sort()
+	var s string
+	var err error
+	switch cli.opts.format {
+	case "csv":
+		s, err = report.ToCSV()
+	case "json":
+		s, err = report.ToJSON()
+	case "yaml":
+		s, err = report.ToYAML()
+	default:
+		s = report.ToText(cli.opts.year, cli.opts.currency)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(cli.Stdout, s)
+	return cli.saveCaches()
+}
+
+// lotMethod returns the configured ledger lot matching method.
+func (cli *cli) lotMethod() string {
+	if cli.config == nil {
+		return ""
+	}
+	return cli.config.LotMethod
+}
+
+// ledgerHoldings assigns the holdings, cost and realized gains derived from ledger `l` as of date `asOf` ("YYYY-MM-DD",
+// blank for all entries) to portfolio `p` and returns the ledger disposals.
+func (cli *cli) ledgerHoldings(p *portfolio.Portfolio, l ledger.Ledger, asOf string) (ledger.Disposals, error) {
+	holdings, disposals, err := l.Holdings(cli.lotMethod(), cli.xrates.GetRateOn, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("portfolio \"%s\": ledger: %s", p.Name, err.Error())
+	}
+	p.Assets = portfolio.Assets{}
+	for _, h := range holdings {
+		p.Assets = append(p.Assets, portfolio.Asset{Symbol: h.Asset, Amount: h.Amount, Cost: h.Cost, Realized: h.Realized})
+	}
+	p.Cost = holdings.Cost()
+	p.Realized = disposals.Gains()
+	return disposals, nil
+}
+
+// assetConfig is a portfolios configuration file asset entry.
+// An entry is either an amount or an amount and a cost e.g. `{amount: 0.5, cost: 15000 AUD}`.
+type assetConfig struct {
+	Amount float64 `yaml:"amount"`
+	Cost   string  `yaml:"cost"`
+}
+
+// UnmarshalYAML decodes plain amount and amount and cost asset entries.
+func (a *assetConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.Amount)
+	}
+	type plain assetConfig // Prevents recursive UnmarshalYAML calls
+	return node.Decode((*plain)(a))
+}
+
 // loadConfigFile reads portfolios configuration file.
 func (cli *cli) loadConfigFile(filename string) (portfolio.Portfolios, error) {
 	type Config []struct {
-		Name   string             `yaml:"name"`
-		Notes  string             `yaml:"notes"`
-		Cost   string             `yaml:"cost"`
-		Assets map[string]float64 `yaml:"assets"`
+		Name      string                 `yaml:"name"`
+		Notes     string                 `yaml:"notes"`
+		Cost      string                 `yaml:"cost"`
+		CostDate  string                 `yaml:"cost-date"`
+		Assets    map[string]assetConfig `yaml:"assets"`
+		Ledger    ledger.Ledger          `yaml:"ledger"`
+		Targets   map[string]float64     `yaml:"targets"`
+		Groups    map[string][]string    `yaml:"groups"`
+		DriftBand float64                `yaml:"drift-band"`
 	}
 	res := portfolio.Portfolios{}
 	s, err := fsx.ReadFile(filename)
@@ -429,7 +1216,7 @@ func (cli *cli) loadConfigFile(filename string) (portfolio.Portfolios, error) {
 	err = yaml.Unmarshal([]byte(s), &config)
 	if err != nil {
 		// Try to parse a minimal portfolio
-		assets := make(map[string]float64)
+		assets := make(map[string]assetConfig)
 		err = yaml.Unmarshal([]byte(s), &assets)
 		if err != nil {
 			return res, err
@@ -450,7 +1237,7 @@ func (cli *cli) loadConfigFile(filename string) (portfolio.Portfolios, error) {
 		for k, v := range c.Assets {
 			asset := portfolio.Asset{}
 			asset.Symbol = strings.ToUpper(k)
-			asset.Amount = v
+			asset.Amount = v.Amount
 			p.Assets = append(p.Assets, asset)
 		}
 		res = append(res, p)
@@ -475,6 +1262,42 @@ func (cli *cli) loadConfigFile(filename string) (portfolio.Portfolios, error) {
 			}
 		}
 	}
+	// Derive ledger portfolio holdings and costs as of the valuation date.
+	cli.ledgers = make(map[string]ledger.Ledger)
+	cli.disposals = make(map[string]ledger.Disposals)
+	asOf := ""
+	if !cli.opts.date.IsZero() {
+		asOf = cli.opts.date.Format("2006-01-02")
+	}
+	for i, c := range config {
+		if len(c.Ledger) == 0 {
+			continue
+		}
+		if len(c.Assets) > 0 || c.Cost != "" {
+			return res, fmt.Errorf("portfolio \"%s\": ledger cannot be combined with assets or cost", res[i].Name)
+		}
+		disposals, err := cli.ledgerHoldings(&res[i], c.Ledger, asOf)
+		if err != nil {
+			return res, err
+		}
+		cli.ledgers[res[i].Name] = c.Ledger
+		cli.disposals[res[i].Name] = disposals
+	}
+	// Assign target allocations.
+	for i, c := range config {
+		targets, err := portfolio.NewTargets(c.Targets, c.Groups)
+		if err != nil {
+			return res, fmt.Errorf("portfolio \"%s\": %s", res[i].Name, err.Error())
+		}
+		res[i].Targets = targets
+		if c.DriftBand < 0 {
+			return res, fmt.Errorf("portfolio \"%s\": invalid drift-band: %.2f", res[i].Name, c.DriftBand)
+		}
+		if c.DriftBand > 0 && len(targets) == 0 {
+			return res, fmt.Errorf("portfolio \"%s\": drift-band requires targets", res[i].Name)
+		}
+		res[i].DriftBand = c.DriftBand
+	}
 	// Assign asset price options
 	for symbol, price := range cli.opts.prices {
 		if err := res.SetAssetPrice(symbol, price); err != nil {
@@ -488,40 +1311,75 @@ func (cli *cli) loadConfigFile(filename string) (portfolio.Portfolios, error) {
 			return res, fmt.Errorf("missing portfolio: \"%s\"", name)
 		}
 	}
-	// Calculate portfolio costs in USD (this is done last to avoid loading the exchange rates cache unnecessarily)
-	for i := range config {
-		if config[i].Cost != "" {
-			if config[i].Name != res[i].Name {
+	// Calculate portfolio and asset costs in USD (this is done last to avoid loading the exchange rates cache unnecessarily)
+	for i, c := range config {
+		if c.CostDate != "" {
+			if _, err := time.Parse("2006-01-02", c.CostDate); err != nil {
+				return res, fmt.Errorf("invalid cost-date: \"%s\"", c.CostDate)
+			}
+		}
+		if c.Cost != "" {
+			if c.Name != res[i].Name {
 				panic("out of order portfolios")
 			}
-			usd, err := cli.currencyToUSD(config[i].Cost)
+			amount, currency, err := portfolio.ParseCurrency(c.Cost)
+			if err != nil {
+				return res, err
+			}
+			usd, err := cli.currencyToUSD(amount, currency, c.CostDate)
 			if err != nil {
 				return res, err
 			}
 			res[i].Cost = usd
+			res[i].CostAmount = amount
+			res[i].CostCurrency = currency
+			res[i].CostDate = c.CostDate
+		}
+		costed := 0
+		assetsCost := 0.0
+		for symbol, a := range c.Assets {
+			if a.Cost == "" {
+				continue
+			}
+			symbol = strings.ToUpper(symbol)
+			amount, currency, err := portfolio.ParseCurrency(a.Cost)
+			if err != nil {
+				return res, fmt.Errorf("%s: %s", symbol, err.Error())
+			}
+			usd, err := cli.currencyToUSD(amount, currency, c.CostDate)
+			if err != nil {
+				return res, fmt.Errorf("%s: %s", symbol, err.Error())
+			}
+			res[i].Assets[res[i].Assets.Find(symbol)].Cost = usd
+			costed++
+			assetsCost += usd
+		}
+		if c.Cost == "" && costed > 0 && costed == len(c.Assets) {
+			// The cost of a portfolio whose assets are all costed is the sum of the asset costs.
+			res[i].Cost = assetsCost
 		}
 	}
 	return res, err
 }
 
-// currencyToUSD parses "<value>[<currency]" string and converts to USD.
-func (cli *cli) currencyToUSD(currencyValue string) (value float64, err error) {
-	value, currency, err := portfolio.ParseCurrency(currencyValue)
-	if err != nil {
-		return
+// currencyToUSD converts `value` in fiat `currency` to USD at the exchange rate on `date` ("YYYY-MM-DD").
+// If `date` is blank the valuation date's exchange rate is used.
+func (cli *cli) currencyToUSD(value float64, currency string, date string) (float64, error) {
+	var rate float64
+	var err error
+	if date == "" {
+		rate, err = cli.getRate(currency)
+	} else {
+		rate, err = cli.xrates.GetRateOn(currency, date)
 	}
-	rate, err := cli.xrates.GetCachedRate(currency, false)
 	if err != nil {
-		return
+		return 0, err
 	}
 	if currency == "USD" && rate != 1.00 {
-		err = fmt.Errorf("USD exchange rate should be 1.00: %f", rate)
-		return
+		return 0, fmt.Errorf("USD exchange rate should be 1.00: %f", rate)
 	}
 	if rate <= 0.00 {
-		err = fmt.Errorf("exchange rate is zero or less: %.2f %s", rate, currency)
-		return
+		return 0, fmt.Errorf("exchange rate is zero or less: %.2f %s", rate, currency)
 	}
-	value = value / rate
-	return
+	return value / rate, nil
 }
@@ -2,77 +2,1170 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/cryptor/internal/lockfile"
 	"github.com/srackham/cryptor/internal/mock"
 	"github.com/srackham/cryptor/internal/portfolio"
+	"github.com/srackham/cryptor/internal/price"
 	"github.com/srackham/go-utils/assert"
 	"github.com/srackham/go-utils/fsx"
 	"github.com/srackham/go-utils/helpers"
 )
 
+// mockCli returns a mock cli with a temporary cache directory so that tests do not write cache files to the source tree.
 func mockCli(t *testing.T) *cli {
 	ctx := mock.NewContext()
-	if !fsx.DirExists(ctx.CacheDir) {
-		if err := os.Mkdir(ctx.CacheDir, 0o755); err != nil {
-			t.Fatal(err)
-		}
+	ctx.CacheDir = t.TempDir()
+	return New(&ctx)
+}
+
+func TestLoadConfig(t *testing.T) {
+	cli := mockCli(t)
+	portfoliosFile := path.Join(cli.ConfigDir, "portfolios.yaml")
+	ps, err := cli.loadConfigFile(portfoliosFile)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(ps))
+	assert.Equal(t, 3, len(ps[0].Assets))
+	assert.Equal(t, "personal", ps[0].Name)
+	i := ps[0].Assets.Find("BTC")
+	assert.PassIf(t, i != -1, "missing asset: BTC")
+	assert.Equal(t, portfolio.Asset{
+		Symbol: "BTC",
+		Price:  0,
+		Amount: 0.5,
+		Value:  0.0,
+	}, ps[0].Assets[i])
+	i = ps[1].Assets.Find("ETH")
+	assert.PassIf(t, i != -1, "missing asset: ETH")
+	assert.Equal(t, 2, len(ps[1].Assets))
+	assert.Equal(t, "joint", ps[1].Name)
+	assert.Equal(t, portfolio.Asset{
+		Symbol: "ETH",
+		Price:  0,
+		Amount: 2.5,
+		Value:  0.0,
+	}, ps[1].Assets[i])
+}
+
+func TestValuate(t *testing.T) {
+	cli := mockCli(t)
+	portfoliosFile := path.Join(cli.ConfigDir, "portfolios.yaml")
+	ps, err := cli.loadConfigFile(portfoliosFile)
+	assert.PassIf(t, err == nil, "error reading portfolios file")
+	p := ps[0]
+	err = cli.loadConfig()
+	assert.PassIf(t, err == nil, "%v", err)
+	source, err := cli.newPriceSource()
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "binance", source.Name())
+	err = p.SetUSDValues(context.Background(), source)
+	assert.Equal(t, 52600.0, p.Value)
+	assert.PassIf(t, err == nil, "error pricing portfolio: %v", err)
+	p.Assets.Sort()
+	assert.Equal(t, 50000.0, p.Assets[0].Value)
+	assert.Equal(t, 2500.0, p.Assets[1].Value)
+	assert.Equal(t, 100.0, p.Assets[2].Value)
+	p.Assets[0].Value = 1000.00
+	p.Assets.Sort()
+	assert.Equal(t, 2500.0, p.Assets[0].Value)
+	assert.Equal(t, 1000.0, p.Assets[1].Value)
+	assert.Equal(t, 100.0, p.Assets[2].Value)
+}
+
+func TestInvalidPriceSource(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `price-source: foobar`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err := exec(cli, "cryptor valuate")
+	assert.FailIf(t, err == nil, "invalid price source should generate an error")
+	assert.Contains(t, stderr, `invalid price source: "foobar"`)
+}
+
+func TestCoinGeckoPriceSource(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+BTC: 0.5
+SML: 1000
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `price-source: coingecko`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `
+NAME:  portfolio1
+DATE:  2000-12-01
+TIME:  12:30:00
+VALUE: 50500.00 USD
+            AMOUNT            VALUE    PERCENT       UNIT PRICE
+BTC         0.5000     50000.00 USD     99.01%    100000.00 USD
+SML      1000.0000       500.00 USD      0.99%         0.50 USD
+`
+	wanted = helpers.StripTrailingSpaces(wanted)
+	stdout = helpers.StripTrailingSpaces(stdout)
+	assert.EqualStrings(t, wanted, stdout)
+}
+
+func TestPriceSourceChain(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+BTC: 0.5
+SML: 1000
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+price-sources:
+  - binance
+  - coingecko
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 50500.00 USD")
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+price-sources: [binance]
+asset-sources:
+  sml: binance
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err := exec(cli, "cryptor valuate")
+	assert.FailIf(t, err == nil, "pinned price source should not fall back")
+	assert.Contains(t, stderr, "SML: binance: invalid trading pairs: SMLUSDT, SMLBTC, SMLETH, SMLBNB, SMLFDUSD")
+}
+
+func TestCrossQuotePrice(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+BTC: 0.5
+XYZ: 1000
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `
+NAME:  portfolio1
+DATE:  2000-12-01
+TIME:  12:30:00
+VALUE: 60000.00 USD
+            AMOUNT            VALUE    PERCENT       UNIT PRICE
+BTC         0.5000     50000.00 USD     83.33%    100000.00 USD
+XYZ      1000.0000     10000.00 USD     16.67%        10.00 USD    (XYZBTC*BTCUSDT)
+`
+	wanted = helpers.StripTrailingSpaces(wanted)
+	stdout = helpers.StripTrailingSpaces(stdout)
+	assert.EqualStrings(t, wanted, stdout)
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	stdout, _, err = exec(cli, "cryptor valuate -format json")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, `"route": "XYZBTC*BTCUSDT"`)
+}
+
+func TestHistoricalValuation(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+BTC: 0.5
+XYZ: 1000
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-appid: 1234`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate -date 2000-06-30 -currency AUD")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `
+NAME:  portfolio1
+DATE:  2000-06-30
+TIME:  23:59:59
+VALUE: 70000.00 AUD
+XRATE: 1 USD = 2.00 AUD (2000-06-30)
+            AMOUNT            VALUE    PERCENT       UNIT PRICE
+BTC         0.5000     50000.00 AUD     71.43%    100000.00 AUD
+XYZ      1000.0000     20000.00 AUD     28.57%        20.00 AUD    (XYZBTC*BTCUSDT)
+`
+	wanted = helpers.StripTrailingSpaces(wanted)
+	stdout = helpers.StripTrailingSpaces(stdout)
+	assert.EqualStrings(t, wanted, stdout)
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `price-source: coingecko`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `SML: 1000`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err = exec(cli, "cryptor valuate -date 2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 250.00 USD")
+
+	cli = mockCli(t)
+	_, stderr, err := exec(cli, "cryptor valuate -date 2000-12-01")
+	assert.FailIf(t, err == nil, "current -date should generate an error")
+	assert.Contains(t, stderr, `-date must be earlier than today: "2000-12-01"`)
+
+	cli = mockCli(t)
+	_, stderr, err = exec(cli, "cryptor valuate -date 2000-06-30 -offline")
+	assert.FailIf(t, err == nil, "-date and -offline options should generate an error")
+	assert.Contains(t, stderr, "the -date and -offline options cannot be combined")
+
+	cli = mockCli(t)
+	_, stderr, err = exec(cli, "cryptor valuate -date 30-06-2000")
+	assert.FailIf(t, err == nil, "invalid -date should generate an error")
+	assert.Contains(t, stderr, `invalid -date argument: "30-06-2000"`)
+}
+
+func TestBackfill(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	valuationsFile := path.Join(tmpdir, "valuations.json")
+	err = portfolio.Portfolios{
+		{Name: "portfolio1", Date: "2000-06-28", Time: "10:00:00", Value: 100000, Assets: portfolio.Assets{{Symbol: "BTC", Amount: 1.0, Price: 100000, Value: 100000}}},
+		{Name: "aggregate", Date: "2000-06-28", Time: "10:00:00", Value: 100000, Assets: portfolio.Assets{{Symbol: "BTC", Amount: 1.0, Price: 100000, Value: 100000}}},
+		{Name: "portfolio1", Date: "2000-06-30", Time: "10:00:00", Value: 100000, Assets: portfolio.Assets{{Symbol: "BTC", Amount: 1.0, Price: 100000, Value: 100000}}},
+		{Name: "aggregate", Date: "2000-06-30", Time: "10:00:00", Value: 100000, Assets: portfolio.Assets{{Symbol: "BTC", Amount: 1.0, Price: 100000, Value: 100000}}},
+	}.SaveValuations(valuationsFile)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, stderr, err := exec(cli, "cryptor backfill -from 2000-06-27 -to 2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "backfilled valuations: 2000-06-29\n", stdout)
+	assert.Contains(t, stderr, `WARNING: 2000-06-27: portfolio has no earlier saved valuations: "portfolio1"`)
+	valuations, err := portfolio.LoadValuations(valuationsFile)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 6, len(valuations))
+	got := []string{}
+	for _, v := range valuations {
+		got = append(got, fmt.Sprintf("%s %s %s %.2f %v", v.Date, v.Time, v.Name, v.Value, v.Synthesized))
+	}
+	wanted := []string{
+		"2000-06-28 10:00:00 portfolio1 100000.00 false",
+		"2000-06-28 10:00:00 aggregate 100000.00 false",
+		"2000-06-29 23:59:59 portfolio1 50000.00 true", // Holdings from the 2000-06-28 valuation
+		"2000-06-29 23:59:59 aggregate 50000.00 true",
+		"2000-06-30 10:00:00 portfolio1 100000.00 false",
+		"2000-06-30 10:00:00 aggregate 100000.00 false",
+	}
+	assert.Equal(t, strings.Join(wanted, "\n"), strings.Join(got, "\n"))
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	stdout, _, err = exec(cli, "cryptor backfill -from 2000-06-28 -to 2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "no missing valuations\n", stdout)
+
+	cli = mockCli(t)
+	_, stderr, err = exec(cli, "cryptor backfill")
+	assert.FailIf(t, err == nil, "missing -from option should generate an error")
+	assert.Contains(t, stderr, "missing -from option")
+
+	cli = mockCli(t)
+	_, stderr, err = exec(cli, "cryptor backfill -from 2000-11-01 -to 2000-12-01")
+	assert.FailIf(t, err == nil, "-to today should generate an error")
+	assert.Contains(t, stderr, `-to date must be earlier than today: "2000-12-01"`)
+
+	cli = mockCli(t)
+	_, stderr, err = exec(cli, "cryptor backfill -from 2000-11-02 -to 2000-11-01")
+	assert.FailIf(t, err == nil, "-from after -to should generate an error")
+	assert.Contains(t, stderr, `-from date is after -to date: "2000-11-02"`)
+}
+
+func TestXratesProvider(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	// No config file: the frankfurter provider does not need an app ID.
+	stdout, _, err := exec(cli, "cryptor valuate -currency NZD")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 75000.00 NZD")
+	assert.Equal(t, "frankfurter", cli.xrates.Provider.Name())
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-provider: ecb`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err = exec(cli, "cryptor valuate -currency AUD -date 2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 50000.00 AUD")
+	assert.Equal(t, "ecb", cli.xrates.Provider.Name())
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-provider: foobar`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = os.Remove(cli.xrates.CacheFile)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err := exec(cli, "cryptor valuate -currency EUR")
+	assert.FailIf(t, err == nil, "invalid provider should generate an error")
+	assert.Contains(t, stderr, `invalid xrates-provider: "foobar"`)
+}
+
+func TestCachedRatesFallback(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+xrates-provider: openexchangerates
+xrates-max-age: 3
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(cli.xrates.CacheFile, `{"2000-11-29": {"AUD": 1.7}}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, stderr, err := exec(cli, "cryptor valuate -currency AUD")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 85000.00 AUD\nXRATE: 1 USD = 1.70 AUD (2000-11-29)")
+	assert.Contains(t, stderr, "missing xrates-appid config option (openexchangerates.org App ID): using cached 2000-11-29 exchange rates")
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+xrates-provider: openexchangerates
+xrates-max-age: 1
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err = exec(cli, "cryptor valuate -currency AUD")
+	assert.FailIf(t, err == nil, "expired cached rates should generate an error")
+	assert.Contains(t, stderr, "ERROR: config file:")
+
+	// A zero maximum age disables the cached rates fallback.
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err = fsx.WriteFile(cli.xrates.CacheFile, `{"2000-11-30": {"AUD": 1.7}}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+xrates-provider: openexchangerates
+xrates-max-age: 0
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err = exec(cli, "cryptor valuate -currency AUD")
+	assert.FailIf(t, err == nil, "disabled cached rates should generate an error")
+	assert.Contains(t, stderr, "ERROR: config file:")
+	assert.PassIf(t, !strings.Contains(stderr, "using cached"), "unexpected cached rates: %v", stderr)
+
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-max-age: -1`)
+	assert.PassIf(t, err == nil, "%v", err)
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.FailIf(t, err == nil, "negative maximum age should generate an error")
+	assert.EqualStrings(t, "invalid xrates-max-age: -1", err.Error())
+}
+
+func TestHistoryCurrency(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err := fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-appid: 1234`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = portfolio.Portfolios{
+		{Name: "portfolio1", Date: "2000-06-30", Time: "10:00:00", Value: 50000, Assets: portfolio.Assets{{Symbol: "BTC", Amount: 1.0, Price: 50000, Value: 50000, Allocation: 100}}},
+		{Name: "portfolio1", Date: "2000-12-01", Time: "10:00:00", Value: 100000, Assets: portfolio.Assets{{Symbol: "BTC", Amount: 1.0, Price: 100000, Value: 100000, Allocation: 100}}},
+	}.SaveValuations(path.Join(tmpdir, "valuations.json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor history -currency AUD")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `
+NAME:  portfolio1
+DATE:  2000-06-30
+TIME:  10:00:00
+VALUE: 100000.00 AUD
+XRATE: 1 USD = 2.00 AUD (2000-06-30)
+            AMOUNT            VALUE    PERCENT       UNIT PRICE
+BTC         1.0000    100000.00 AUD    100.00%    100000.00 AUD
+
+NAME:  portfolio1
+DATE:  2000-12-01
+TIME:  10:00:00
+VALUE: 160000.00 AUD
+XRATE: 1 USD = 1.60 AUD (2000-12-01)
+            AMOUNT            VALUE    PERCENT       UNIT PRICE
+BTC         1.0000    160000.00 AUD    100.00%    160000.00 AUD
+`
+	wanted = helpers.StripTrailingSpaces(wanted)
+	stdout = helpers.StripTrailingSpaces(stdout)
+	assert.EqualStrings(t, wanted, stdout)
+	got, err := fsx.ReadFile(path.Join(tmpdir, "exchange-rates.json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, got, `"2000-06-30"`)
+	assert.Contains(t, got, `"2000-12-01"`)
+}
+
+func TestCostDate(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err := fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-appid: 1234`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  cost: 10000 AUD
+  cost-date: 2000-06-30
+  assets:
+    BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate -currency AUD")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 5000.0, cli.valuation[0].Cost)
+	assert.Equal(t, 10000.0, cli.valuation[0].CostAmount)
+	assert.Equal(t, "AUD", cli.valuation[0].CostCurrency)
+	assert.Equal(t, "2000-06-30", cli.valuation[0].CostDate)
+	assert.Equal(t, -1250.0, cli.valuation[0].FXGains)
+	assert.Contains(t, stdout, "COST:  8000.00 AUD (10000.00 AUD on 2000-06-30)")
+	assert.Contains(t, stdout, "FX:    -2000.00 AUD")
+
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  cost: 10000 AUD
+  cost-date: 30-06-2000
+  assets:
+    BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), `invalid cost-date: "30-06-2000"`)
+}
+
+func TestLedgerPortfolio(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  ledger:
+    - {date: 2000-01-10, type: buy, asset: BTC, quantity: 1, price: 40000, fee: 10}
+    - {date: 2000-02-01, type: buy, asset: ETH, quantity: 4, price: 500}
+    - {date: 2000-03-01, type: sell, asset: BTC, quantity: 0.5, price: 60000, fee: 10}
+    - {date: 2000-03-02, type: transfer, asset: ETH, quantity: 4, fee: 0.5}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	p := cli.valuation[0]
+	assert.Equal(t, 2, len(p.Assets))
+	assert.Equal(t, "BTC", p.Assets[0].Symbol)
+	assert.Equal(t, 0.5, p.Assets[0].Amount)
+	assert.Equal(t, 20005.0, p.Assets[0].Cost)
+	assert.Equal(t, 9985.0, p.Assets[0].Realized)
+	assert.Equal(t, "ETH", p.Assets[1].Symbol)
+	assert.Equal(t, 3.5, p.Assets[1].Amount)
+	assert.Equal(t, 1750.0, p.Assets[1].Cost)
+	assert.Equal(t, -250.0, p.Assets[1].Realized)
+	assert.Equal(t, 20005.0+1750.0, p.Cost)
+	assert.Equal(t, 9735.0, p.Realized)
+	assert.Contains(t, stdout, "REALIZED: 9735.00 USD")
+	assert.Contains(t, stdout, "BTC         0.5000     50000.00 USD     93.46%    100000.00 USD     20005.00 USD     29995.00 USD    149.94%      9985.00 USD")
+
+	// Historical valuations exclude ledger entries dated after the valuation date.
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate -date 2000-02-15")
+	assert.PassIf(t, err == nil, "%v", err)
+	p = cli.valuation[0]
+	assert.Equal(t, 2, len(p.Assets))
+	assert.Equal(t, 1.0, p.Assets[0].Amount)
+	assert.Equal(t, 40010.0, p.Assets[0].Cost)
+	assert.Equal(t, 4.0, p.Assets[1].Amount)
+	assert.Equal(t, 0.0, p.Realized)
+
+	// Backfilled ledger portfolio holdings are derived from the ledger entries up to each day.
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	_, _, err = exec(cli, "cryptor backfill -from 2000-02-29 -to 2000-03-01")
+	assert.PassIf(t, err == nil, "%v", err)
+	valuations, err := portfolio.LoadValuations(path.Join(tmpdir, "valuations.json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 4, len(valuations))
+	assert.Equal(t, 1.0, valuations[0].Assets[0].Amount)
+	assert.Equal(t, 0.0, valuations[0].Realized)
+	assert.Equal(t, 0.5, valuations[2].Assets[0].Amount)
+	assert.Equal(t, 9985.0, valuations[2].Realized)
+
+	// Days before the first ledger entry are not backfilled.
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	stdout, stderr, err := exec(cli, "cryptor backfill -from 2000-01-09 -to 2000-01-09")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "", stdout)
+	assert.Contains(t, stderr, `WARNING: 2000-01-09: portfolio has no ledger entries: "portfolio1"`)
+
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `lot-method: lofo`)
+	assert.PassIf(t, err == nil, "%v", err)
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), `portfolio "portfolio1": ledger: invalid lot method: "lofo"`)
+	err = os.Remove(path.Join(tmpdir, "config.yaml"))
+	assert.PassIf(t, err == nil, "%v", err)
+
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  cost: 1000 USD
+  ledger:
+    - {date: 2000-01-10, type: buy, asset: BTC, quantity: 1, price: 40000}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), `portfolio "portfolio1": ledger cannot be combined with assets or cost`)
+
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  ledger:
+    - {date: 2000-01-10, type: sell, asset: BTC, quantity: 1, price: 40000}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), `portfolio "portfolio1": ledger: 2000-01-10 sell 1 BTC: insufficient BTC holdings: 0`)
+}
+
+func TestAssetCosts(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err := fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-appid: 1234`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  assets:
+    BTC: {amount: 0.5, cost: 16000 AUD}
+    ETH: 2
+- name: portfolio2
+  assets:
+    BTC:
+      amount: 0.1
+      cost: $4,000
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate -aggregate")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `
+NAME:  portfolio1
+DATE:  2000-12-01
+TIME:  12:30:00
+VALUE: 52000.00 USD
+            AMOUNT            VALUE    PERCENT       UNIT PRICE             COST            GAINS     GAINS%
+BTC         0.5000     50000.00 USD     96.15%    100000.00 USD     10000.00 USD     40000.00 USD    400.00%
+ETH         2.0000      2000.00 USD      3.85%      1000.00 USD
+
+NAME:  portfolio2
+DATE:  2000-12-01
+TIME:  12:30:00
+VALUE: 10000.00 USD
+COST:  4000.00 USD
+GAINS: 6000.00 USD (150.00%)
+            AMOUNT            VALUE    PERCENT       UNIT PRICE             COST            GAINS     GAINS%
+BTC         0.1000     10000.00 USD    100.00%    100000.00 USD      4000.00 USD      6000.00 USD    150.00%
+
+NAME:  aggregate
+NOTES: portfolio1, portfolio2
+DATE:  2000-12-01
+TIME:  12:30:00
+VALUE: 62000.00 USD
+            AMOUNT            VALUE    PERCENT       UNIT PRICE             COST            GAINS     GAINS%
+BTC         0.6000     60000.00 USD     96.77%    100000.00 USD     14000.00 USD     46000.00 USD    328.57%
+ETH         2.0000      2000.00 USD      3.23%      1000.00 USD
+`
+	assert.EqualStrings(t, wanted, stdout)
+	assert.Equal(t, 10000.0, cli.valuation[0].Assets[0].Cost)
+	assert.Equal(t, 40000.0, cli.valuation[0].Assets[0].Gains)
+	assert.Equal(t, 400.0, cli.valuation[0].Assets[0].GainsPct)
+	assert.Equal(t, 0.0, cli.valuation[0].Cost)
+
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  assets:
+    BTC: {amount: 0.5, cost: 16000 XYZ}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), "BTC: unknown currency: XYZ")
+}
+
+func TestTaxReport(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	newCli := func() *cli {
+		cli := mockCli(t)
+		cli.ConfigDir = tmpdir
+		cli.CacheDir = tmpdir
+		cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+		return cli
+	}
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  ledger:
+    - {date: 1999-01-01, type: buy, asset: ETH, quantity: 4, price: 100}
+    - {date: 2000-06-30, type: buy, asset: BTC, quantity: 1, price: 10000, fee: 10}
+    - {date: 2000-06-30, type: sell, asset: ETH, quantity: 2, price: 500}
+    - {date: 2000-12-01, type: sell, asset: BTC, quantity: 0.5, price: 60000, fee: 10}
+    - {date: 2001-01-02, type: sell, asset: ETH, quantity: 1, price: 600}
+- name: portfolio2
+  assets:
+    BTC: 1`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(newCli(), "cryptor tax-report -year 2000")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `YEAR:       2000
+SHORT-TERM: 24985.00 USD
+LONG-TERM:  800.00 USD
+TOTAL:      25785.00 USD
+PORTFOLIO        ASSET TYPE        QUANTITY ACQUIRED   DISPOSED           PROCEEDS             COST             GAIN TERM
+portfolio1       ETH   sell          2.0000 1999-01-01 2000-06-30      1000.00 USD       200.00 USD       800.00 USD long
+portfolio1       BTC   sell          0.5000 2000-06-30 2000-12-01     29990.00 USD      5005.00 USD     24985.00 USD short
+`
+	assert.EqualStrings(t, wanted, stdout)
+
+	stdout, _, err = exec(newCli(), "cryptor tax-report -year 2000 -format csv")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted = `portfolio,asset,type,quantity,acquired,disposed,proceeds,cost,gain,term,currency
+portfolio1,ETH,sell,2,1999-01-01,2000-06-30,1000.00,200.00,800.00,long,USD
+portfolio1,BTC,sell,0.5,2000-06-30,2000-12-01,29990.00,5005.00,24985.00,short,USD
+`
+	assert.EqualStrings(t, wanted, stdout)
+
+	stdout, _, err = exec(newCli(), "cryptor tax-report -year 2001 -format json -portfolio portfolio1")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted = `[
+  {
+    "portfolio": "portfolio1",
+    "asset": "ETH",
+    "type": "sell",
+    "quantity": 1,
+    "acquired": "1999-01-01",
+    "disposed": "2001-01-02",
+    "proceeds": 600,
+    "cost": 100,
+    "gain": 500,
+    "term": "long",
+    "currency": "USD"
+  }
+]
+`
+	assert.EqualStrings(t, wanted, stdout)
+
+	// Non-USD reports are converted at the acquisition and disposal date exchange rates.
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  ledger:
+    - {date: 2000-06-30, type: buy, asset: BTC, quantity: 1, price: 10000}
+    - {date: 2000-12-01, type: sell, asset: BTC, quantity: 0.5, price: 60000}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), "xrates-appid: 1234\nlong-term-days: 100")
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err = exec(newCli(), "cryptor tax-report -year 2000 -currency AUD -format csv")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted = `portfolio,asset,type,quantity,acquired,disposed,proceeds,cost,gain,term,currency
+portfolio1,BTC,sell,0.5,2000-06-30,2000-12-01,48000.00,10000.00,38000.00,long,AUD
+`
+	assert.EqualStrings(t, wanted, stdout)
+
+	// Network fees are disposals with zero proceeds.
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  ledger:
+    - {date: 2000-06-30, type: buy, asset: BTC, quantity: 1, price: 10000}
+    - {date: 2000-12-01, type: transfer, asset: BTC, quantity: 0.5, fee: 0.001}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), "lot-method: fifo")
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err = exec(newCli(), "cryptor tax-report -year 2000 -format csv")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted = `portfolio,asset,type,quantity,acquired,disposed,proceeds,cost,gain,term,currency
+portfolio1,BTC,fee,0.001,2000-06-30,2000-12-01,0.00,10.00,-10.00,short,USD
+`
+	assert.EqualStrings(t, wanted, stdout)
+
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), "lot-method: average")
+	assert.PassIf(t, err == nil, "%v", err)
+	_, _, err = exec(newCli(), "cryptor tax-report -year 2000")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `the tax-report command does not support lot-method: "average"`, err.Error())
+
+	_, _, err = exec(newCli(), "cryptor tax-report")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, "missing -year option", err.Error())
+	_, _, err = exec(newCli(), "cryptor valuate -format csv")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, "-format csv is only valid for the tax-report command", err.Error())
+}
+
+func TestRebalance(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	newCli := func() *cli {
+		cli := mockCli(t)
+		cli.ConfigDir = tmpdir
+		cli.CacheDir = tmpdir
+		cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+		return cli
 	}
-	return New(&ctx)
+	err := fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `xrates-appid: 1234`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  assets:
+    BTC: 0.5
+    ETH: 10
+    USDC: 100
+  targets:
+    BTC: 60
+    ETH: 40
+- name: portfolio2
+  assets:
+    BTC: 1
+  targets:
+    BTC: 50
+    stable: 50
+  groups:
+    stable: [USDC, USDT]
+- name: portfolio3
+  assets:
+    ETH: 1`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(newCli(), "cryptor rebalance")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `
+NAME:  portfolio1
+DATE:  2000-12-01
+VALUE: 60100.00 USD
+CASH:  0.00 USD
+TRADE SYMBOL         AMOUNT            VALUE    CURRENT     TARGET
+buy   ETH         14.0400     14040.00 USD     16.64%     40.00%
+sell  USDC       100.0000       100.00 USD      0.17%      0.00%
+sell  BTC          0.1394     13940.00 USD     83.19%     60.00%
+
+NAME:  portfolio2
+DATE:  2000-12-01
+VALUE: 100000.00 USD
+CASH:  0.00 USD
+TRADE SYMBOL         AMOUNT            VALUE    CURRENT     TARGET
+buy   USDC     25000.0000     25000.00 USD      0.00%     50.00%    (stable)
+buy   USDT     25000.0000     25000.00 USD      0.00%     50.00%    (stable)
+sell  BTC          0.5000     50000.00 USD    100.00%     50.00%
+
+`
+	assert.EqualStrings(t, wanted, stdout)
+
+	// Untargeted assets are retained and trades under 1000 AUD are omitted.
+	stdout, _, err = exec(newCli(), "cryptor rebalance -portfolio portfolio1 -currency AUD -min-trade 1000 -no-sell")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted = `
+NAME:  portfolio1
+DATE:  2000-12-01
+VALUE: 96160.00 AUD
+CASH:  37333.33 AUD
+TRADE SYMBOL         AMOUNT            VALUE    CURRENT     TARGET
+buy   ETH         23.3333     37333.33 AUD     16.64%     40.00%
+
+`
+	assert.EqualStrings(t, wanted, stdout)
+
+	_, _, err = exec(newCli(), "cryptor rebalance -portfolio portfolio3")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `portfolio has no targets: "portfolio3"`, err.Error())
+	_, _, err = exec(newCli(), "cryptor rebalance -min-trade -1")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `invalid -min-trade argument: "-1"`, err.Error())
 }
 
-func TestLoadConfig(t *testing.T) {
+func TestDriftAlerts(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	newCli := func() *cli {
+		cli := mockCli(t)
+		cli.ConfigDir = tmpdir
+		cli.CacheDir = tmpdir
+		cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+		return cli
+	}
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  assets:
+    BTC: 0.5
+    ETH: 10
+  targets:
+    BTC: 60
+    ETH: 40
+  drift-band: 5
+- name: portfolio2
+  assets:
+    BTC: 0.5
+    ETH: 50
+  targets:
+    BTC: 50
+    ETH: 50
+  drift-band: 5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err := exec(newCli(), "cryptor valuate")
+	assert.PassIf(t, errors.Is(err, ErrDriftDetected), "unexpected error: %v", err)
+	wanted := `WARNING: portfolio1: BTC allocation 83.33% is outside the 60.00% ±5.00% target band
+WARNING: portfolio1: ETH allocation 16.67% is outside the 40.00% ±5.00% target band
+`
+	assert.EqualStrings(t, wanted, stderr)
+
+	// All portfolios are checked whichever valuations are printed.
+	_, stderr, err = exec(newCli(), "cryptor valuate -portfolio portfolio2")
+	assert.PassIf(t, errors.Is(err, ErrDriftDetected), "unexpected error: %v", err)
+	assert.EqualStrings(t, wanted, stderr)
+	_, stderr, err = exec(newCli(), "cryptor valuate -aggregate-only")
+	assert.PassIf(t, errors.Is(err, ErrDriftDetected), "unexpected error: %v", err)
+	assert.EqualStrings(t, wanted, stderr)
+
+	// Drift is reported if alerts cannot be evaluated or sent and the alert errors do not hide the drift error.
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+alerts:
+  rules:
+    - missing value below 10`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err = exec(newCli(), "cryptor valuate")
+	assert.PassIf(t, errors.Is(err, ErrDriftDetected), "unexpected error: %v", err)
+	assert.Contains(t, stderr, wanted)
+	assert.Contains(t, stderr, `ERROR: alert rule portfolio not found: "missing value below 10"`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+alerts:
+  rules:
+    - BTC price above 90000
+  notifiers:
+    - type: webhook
+      url: `+server.URL)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err = exec(newCli(), "cryptor valuate")
+	assert.PassIf(t, errors.Is(err, ErrDriftDetected), "unexpected error: %v", err)
+	assert.Contains(t, stderr, wanted)
+	assert.Contains(t, stderr, `ERROR: webhook notifier: "`+server.URL+`": 500 Internal Server Error`)
+	err = os.Remove(path.Join(tmpdir, "config.yaml"))
+	assert.PassIf(t, err == nil, "%v", err)
+
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: portfolio1
+  assets:
+    BTC: 0.5
+  drift-band: 5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, _, err = exec(newCli(), "cryptor valuate")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), `portfolio "portfolio1": drift-band requires targets`)
+}
+
+func TestAlerts(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	tmpdir := mock.MkdirTemp(t)
 	cli := mockCli(t)
-	portfoliosFile := path.Join(cli.ConfigDir, "portfolios.yaml")
-	ps, err := cli.loadConfigFile(portfoliosFile)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	err := fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+alerts:
+  rules:
+    - BTC price above 90000
+    - BTC price below 90000
+    - personal value up 20% in 24h
+  notifiers:
+    - type: stdout
+    - type: webhook
+      url: `+server.URL)
 	assert.PassIf(t, err == nil, "%v", err)
-	assert.Equal(t, 3, len(ps))
-	assert.Equal(t, 3, len(ps[0].Assets))
-	assert.Equal(t, "personal", ps[0].Name)
-	i := ps[0].Assets.Find("BTC")
-	assert.PassIf(t, i != -1, "missing asset: BTC")
-	assert.Equal(t, portfolio.Asset{
-		Symbol: "BTC",
-		Price:  0,
-		Amount: 0.5,
-		Value:  0.0,
-	}, ps[0].Assets[i])
-	i = ps[1].Assets.Find("ETH")
-	assert.PassIf(t, i != -1, "missing asset: ETH")
-	assert.Equal(t, 2, len(ps[1].Assets))
-	assert.Equal(t, "joint", ps[1].Name)
-	assert.Equal(t, portfolio.Asset{
-		Symbol: "ETH",
-		Price:  0,
-		Amount: 2.5,
-		Value:  0.0,
-	}, ps[1].Assets[i])
+	err = fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+- name: personal
+  assets:
+    BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	// The latest saved valuation is the baseline.
+	saved := portfolio.Portfolios{{Name: "personal", Date: "2000-11-30", Time: "18:00:00", Value: 40000}}
+	err = saved.SaveValuations(path.Join(tmpdir, "valuations.json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor valuate -format json -save")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, `
+ALERT: BTC price 100000.00 USD is above 90000.00 USD
+ALERT: personal value 50000.00 USD is up 25.00% since 2000-11-30 18:00:00
+`)
+	assert.Contains(t, string(body), `{"rule":"personal value up 20% in 24h","message":"personal value 50000.00 USD is up 25.00% since 2000-11-30 18:00:00","date":"2000-12-01","time":"12:30:00"}`)
+
+	// Alerts are not evaluated for historical valuations.
+	body = nil
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	stdout, _, err = exec(cli, "cryptor valuate -date 2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 25000.00 USD")
+	assert.PassIf(t, !strings.Contains(stdout, "ALERT:"), "unexpected alert: %v", stdout)
+	assert.PassIf(t, body == nil, "unexpected webhook request: %v", string(body))
+
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+alerts:
+  rules:
+    - BTC price sideways 90000`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, _, err = exec(mockCli(t), "cryptor valuate -confdir "+tmpdir)
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `invalid alert rule operator: "BTC price sideways 90000"`, err.Error())
 }
 
-func TestValuate(t *testing.T) {
+func TestConsensusPriceMode(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
 	cli := mockCli(t)
-	portfoliosFile := path.Join(cli.ConfigDir, "portfolios.yaml")
-	ps, err := cli.loadConfigFile(portfoliosFile)
-	assert.PassIf(t, err == nil, "error reading portfolios file")
-	p := ps[0]
-	reader := cli.priceReader
-	err = p.SetUSDValues(reader)
-	assert.Equal(t, 52600.0, p.Value)
-	assert.PassIf(t, err == nil, "error pricing portfolio: %v", err)
-	p.Assets.Sort()
-	assert.Equal(t, 50000.0, p.Assets[0].Value)
-	assert.Equal(t, 2500.0, p.Assets[1].Value)
-	assert.Equal(t, 100.0, p.Assets[2].Value)
-	p.Assets[0].Value = 1000.00
-	p.Assets.Sort()
-	assert.Equal(t, 2500.0, p.Assets[0].Value)
-	assert.Equal(t, 1000.0, p.Assets[1].Value)
-	assert.Equal(t, 100.0, p.Assets[2].Value)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+BTC: 0.5
+SML: 1000
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+price-mode: consensus
+price-sources: [binance, coingecko]
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, stderr, err := exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 50500.00 USD")
+	assert.Equal(t, "", stderr)
+	_, ok := cli.priceSource.(*price.Pegged).Source().(*price.Consensus)
+	assert.PassIf(t, ok, "expected consensus price source: %T", cli.priceSource.(*price.Pegged).Source())
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `price-mode: foobar`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err = exec(cli, "cryptor valuate")
+	assert.FailIf(t, err == nil, "invalid price mode should generate an error")
+	assert.Contains(t, stderr, `invalid price-mode: "foobar"`)
+}
+
+func TestStablecoins(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `
+USDC: 1000
+DAI: 1000
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+asset-sources:
+  DAI: coingecko
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, stderr, err := exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 1950.00 USD")
+	assert.Equal(t, "WARNING: DAI: coingecko price 0.9500 USD deviates -5.00% from its USD peg price 1.0000 USD\n", stderr)
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+xrates-appid: 1234
+stablecoin-mode: peg
+stablecoins:
+  dai: aud
+asset-sources:
+  DAI: coingecko
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, stderr, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 1625.00 USD")
+	assert.Contains(t, stdout, "DAI      1000.0000       625.00 USD")
+	assert.Equal(t, "WARNING: DAI: coingecko price 0.9500 USD deviates 52.00% from its AUD peg price 0.6250 USD\n", stderr)
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.DataDir = tmpdir
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `stablecoin-mode: foobar`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err = exec(cli, "cryptor valuate")
+	assert.FailIf(t, err == nil, "invalid stablecoin mode should generate an error")
+	assert.Contains(t, stderr, `invalid stablecoin-mode: "foobar"`)
+}
+
+func TestBatchPriceRequest(t *testing.T) {
+	cli := mockCli(t)
+	urls := []string{}
+	httpGet := cli.HttpGetWithContext
+	cli.HttpGetWithContext = func(ctx context.Context, url string) (*http.Response, error) {
+		urls = append(urls, url)
+		return httpGet(ctx, url)
+	}
+	_, _, err := exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1, len(urls))
+	assert.Contains(t, urls[0], PRICES_QUERY)
+}
+
+func TestOfflineValuation(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cli := mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	err := fsx.WriteFile(path.Join(tmpdir, "portfolios.yaml"), `BTC: 0.5`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, stderr, err := exec(cli, "cryptor valuate -offline")
+	assert.FailIf(t, err == nil, "offline valuation without cached prices should generate an error")
+	assert.Contains(t, stderr, "offline: no cached price: BTC")
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.PassIf(t, fsx.FileExists(path.Join(tmpdir, "binance-prices.json")), "missing prices cache file")
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.HttpGetWithContext = nil // Offline valuations must not make price requests
+	stdout, _, err := exec(cli, "cryptor valuate -offline")
+	assert.PassIf(t, err == nil, "%v", err)
+	wanted := `
+NAME:  portfolio1
+DATE:  2000-12-01
+TIME:  12:30:00
+VALUE: 50000.00 USD
+STALE: valuated with offline cached prices
+            AMOUNT            VALUE    PERCENT       UNIT PRICE
+BTC         0.5000     50000.00 USD    100.00%    100000.00 USD
+`
+	wanted = helpers.StripTrailingSpaces(wanted)
+	stdout = helpers.StripTrailingSpaces(stdout)
+	assert.EqualStrings(t, wanted, stdout)
+	assert.PassIf(t, cli.aggregate.Stale, "aggregate valuation should be stale")
+
+	// Offline valuations use the newest cached exchange rates regardless of their age.
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	cli.HttpGet = nil // Offline valuations must not make exchange rate requests
+	cli.HttpGetWithContext = nil
+	err = fsx.WriteFile(cli.xrates.CacheFile, `{"2000-10-01": {"AUD": 1.5}}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err = exec(cli, "cryptor valuate -offline -currency AUD")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 75000.00 AUD\nSTALE: valuated with offline cached prices\nXRATE: 1 USD = 1.50 AUD (2000-10-01)")
+
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+	cli.HttpGet = nil
+	cli.HttpGetWithContext = nil
+	_, stderr, err = exec(cli, "cryptor valuate -offline -currency NZD")
+	assert.FailIf(t, err == nil, "offline valuation without cached rates should generate an error")
+	assert.Contains(t, stderr, "unknown currency: NZD")
+
+	// Consensus valuations are stale if any of the median quotes are stale.
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `
+price-mode: consensus
+price-sources: [binance, coingecko]
+`)
+	assert.PassIf(t, err == nil, "%v", err)
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	_, _, err = exec(cli, "cryptor valuate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.PassIf(t, !cli.aggregate.Stale, "aggregate valuation should not be stale")
+	cli = mockCli(t)
+	cli.ConfigDir = tmpdir
+	cli.CacheDir = tmpdir
+	cli.DataDir = tmpdir
+	cli.HttpGetWithContext = nil
+	stdout, _, err = exec(cli, "cryptor valuate -offline")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "VALUE: 50000.00 USD\nSTALE: valuated with offline cached prices")
+	assert.PassIf(t, cli.aggregate.Stale, "aggregate valuation should be stale")
 }
 
 func TestParseArgs(t *testing.T) {
@@ -302,6 +1395,7 @@ USDC      100.0000       100.00 USD      0.08%         1.00 USD
 	wanted = `{
   "2000-12-01": {
     "AUD": 1.6,
+    "EUR": 0.8,
     "NZD": 1.5,
     "USD": 1
   }
@@ -313,7 +1407,7 @@ NOTES: joint, personal, portfolio1
 DATE:  2000-12-01
 TIME:  12:30:00
 VALUE: 195150.00 NZD
-XRATE: 1 USD = 1.50 NZD
+XRATE: 1 USD = 1.50 NZD (2000-12-01)
             AMOUNT            VALUE    PERCENT       UNIT PRICE
 BTC         1.2500    187500.00 NZD     96.08%    150000.00 NZD
 ETH         5.0000      7500.00 NZD      3.84%      1500.00 NZD
@@ -336,11 +1430,15 @@ Description:
     Cryptor valuates crypto currency asset portfolios.
 
 Commands:
-    init     create configuration directory and install default config and
-             example portfolios files
-    valuate  valuate, print and save portfolio valuations
-    history  Print saved portfolio valuations
-    help     display documentation`)
+    init       create configuration directory and install default config and
+               example portfolios files
+    valuate    valuate, print and save portfolio valuations
+    history    Print saved portfolio valuations
+    backfill   save historical valuations for days with no saved valuations
+    tax-report print ledger disposals capital gains for a tax year
+    rebalance  print the trades that rebalance portfolios to their target allocations
+    migrate    convert the valuations JSON file to the configured valuations store
+    help       display documentation`)
 }
 
 func TestInitCmd(t *testing.T) {
@@ -352,9 +1450,11 @@ func TestInitCmd(t *testing.T) {
 	assert.Contains(t, stdout, `installing example config file:`)
 	s, err := fsx.ReadFile(cli.configFile())
 	assert.PassIf(t, err == nil, "%v", err)
-	assert.EqualStrings(t, `# NOTE: The xrates-appid option is only necessary if the -currency command option is used.
-# Open Exchange Rates App ID (https://openexchangerates.org/)
-xrates-appid: YOUR_APP_ID`, s)
+	assert.EqualStrings(t, `# Fiat currency exchange rates provider used by the -currency command option:
+# frankfurter (the default), ecb or openexchangerates.
+# xrates-provider: frankfurter
+# Open Exchange Rates App ID (https://openexchangerates.org/), only necessary for the openexchangerates provider.
+# xrates-appid: YOUR_APP_ID`, s)
 	assert.Contains(t, stdout, `installing example portfolios file:`)
 	s, err = fsx.ReadFile(cli.portfoliosFile())
 	assert.PassIf(t, err == nil, "%v", err)
@@ -422,6 +1522,52 @@ func TestSaveValuation(t *testing.T) {
 		"valuations file: \"%v\": expected:\n%v\n\ngot:\n%v", valuationsFile, savedValuations, loadedValuations)
 }
 
+func TestConcurrentSaves(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	cacheDir := path.Join(tmpdir, "cache")
+	valuationsFile := path.Join(tmpdir, "valuations.json")
+	save := func() <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			cli := mockCli(t)
+			cli.DataDir = tmpdir
+			cli.CacheDir = cacheDir
+			cli.xrates.CacheFile = path.Join(cacheDir, "exchange-rates.json")
+			_, _, err := exec(cli, "cryptor valuate -save")
+			done <- err
+		}()
+		return done
+	}
+	// A save waits for the valuations file lock held by another process.
+	l, err := lockfile.New(valuationsFile)
+	assert.PassIf(t, err == nil, "%v", err)
+	done := save()
+	select {
+	case err := <-done:
+		t.Fatalf("save did not wait for the valuations file lock: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	err = portfolio.Portfolios{{Name: "other", Date: "2000-12-01", Assets: portfolio.Assets{}}}.SaveValuations(valuationsFile)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.PassIf(t, l.Unlock() == nil, "unlock error")
+	err = <-done
+	assert.PassIf(t, err == nil, "%v", err)
+	// Concurrent saves do not lose valuations.
+	errs := []<-chan error{}
+	for range 4 {
+		errs = append(errs, save())
+	}
+	for _, done := range errs {
+		err := <-done
+		assert.PassIf(t, err == nil, "%v", err)
+	}
+	valuations, err := portfolio.LoadValuations(valuationsFile)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1+5*4, len(valuations))
+	assert.Equal(t, "other", valuations[0].Name)
+	assert.PassIf(t, fsx.FileExists(valuationsFile+".bak"), "missing valuations backup file")
+}
+
 func TestMissingPortfolio(t *testing.T) {
 	cli := mockCli(t)
 	_, stderr, err := exec(cli, "cryptor valuate -portfolio non-existent")
@@ -474,6 +1620,101 @@ func TestHistoryCmd(t *testing.T) {
 	assert.Contains(t, stderr, "no valuations found")
 }
 
+func TestMigrateCmd(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	newCli := func() *cli {
+		cli := mockCli(t)
+		cli.CacheDir = tmpdir
+		cli.DataDir = tmpdir
+		cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+		return cli
+	}
+	cli := newCli()
+	valuations, err := portfolio.LoadValuations("../../testdata/data/valuations.json")
+	assert.PassIf(t, err == nil, "%v", err)
+	err = valuations.SaveValuations(cli.valuationsFile("json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(cli, "cryptor migrate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "migrated 15 valuations to: ")
+	assert.PassIf(t, !fsx.FileExists(cli.valuationsFile("json")), "valuations JSON file should have been renamed")
+	assert.PassIf(t, fsx.FileExists(cli.valuationsFile("json")+".bak"), "missing valuations JSON backup file")
+	migrated, err := portfolio.LoadValuations(cli.valuationsFile("jsonl"))
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.PassIf(t, reflect.DeepEqual(valuations, migrated), "valuations file: expected:\n%v\n\ngot:\n%v", valuations, migrated)
+
+	// Valuations are appended to the JSON Lines file.
+	_, _, err = exec(newCli(), "cryptor valuate -save")
+	assert.PassIf(t, err == nil, "%v", err)
+	s, err := fsx.ReadFile(newCli().valuationsFile("jsonl"))
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 19, strings.Count(s, "\n"))
+	stdout, _, err = exec(newCli(), "cryptor history -portfolio joint")
+	assert.PassIf(t, err == nil, "%v", err)
+	valuations = portfolio.Portfolios{}
+	err = json.Unmarshal([]byte(stdout), &valuations)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 8, len(valuations))
+	assert.Equal(t, "2000-12-01", valuations[7].Date)
+
+	_, _, err = exec(newCli(), "cryptor migrate")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), "valuations file already exists: ")
+}
+
+func TestSQLiteStore(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	newCli := func() *cli {
+		cli := mockCli(t)
+		cli.ConfigDir = tmpdir
+		cli.CacheDir = tmpdir
+		cli.DataDir = tmpdir
+		cli.xrates.CacheFile = path.Join(tmpdir, "exchange-rates.json")
+		return cli
+	}
+	err := fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `valuations-store: sqlite`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = fsx.CopyFile("../../testdata/portfolios.yaml", path.Join(tmpdir, "portfolios.yaml"))
+	assert.PassIf(t, err == nil, "%v", err)
+	valuations, err := portfolio.LoadValuations("../../testdata/data/valuations.json")
+	assert.PassIf(t, err == nil, "%v", err)
+	err = valuations.SaveValuations(path.Join(tmpdir, "valuations.json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	stdout, _, err := exec(newCli(), "cryptor migrate")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stdout, "migrated 15 valuations to: ")
+	assert.PassIf(t, fsx.FileExists(path.Join(tmpdir, "valuations.db")), "missing valuations database")
+
+	_, _, err = exec(newCli(), "cryptor valuate -save")
+	assert.PassIf(t, err == nil, "%v", err)
+	history := func(args string) portfolio.Portfolios {
+		stdout, _, err := exec(newCli(), "cryptor history"+args)
+		assert.PassIf(t, err == nil, "%v", err)
+		res := portfolio.Portfolios{}
+		err = json.Unmarshal([]byte(stdout), &res)
+		assert.PassIf(t, err == nil, "%v", err)
+		return res
+	}
+	assert.Equal(t, 19, len(history("")))
+	assert.Equal(t, 8, len(history(" -portfolio joint")))
+	result := history(" -from 2022-12-03 -to 2022-12-04 -portfolio personal")
+	assert.Equal(t, 2, len(result))
+	assert.Equal(t, "2022-12-03", result[0].Date)
+	result = history(" -symbol usdc -to 2000-12-31") // The test data includes an aggregate valuation dated 2000-12-01
+	assert.Equal(t, 3, len(result))
+	assert.Equal(t, "personal", result[1].Name)
+	assert.Equal(t, "2000-12-01", result[1].Date)
+
+	_, _, err = exec(newCli(), "cryptor history -symbol XYZ")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.Contains(t, err.Error(), "no valuations found")
+	err = fsx.WriteFile(path.Join(tmpdir, "config.yaml"), `valuations-store: csv`)
+	assert.PassIf(t, err == nil, "%v", err)
+	_, _, err = exec(newCli(), "cryptor history")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `invalid valuations-store: "csv"`, err.Error())
+}
+
 func TestNoConfigFile(t *testing.T) {
 	tmpdir := mock.MkdirTemp(t)
 	cli := mockCli(t)
@@ -0,0 +1,191 @@
+// CoinGecko price.PriceSource interface implementation.
+package coingecko
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/cryptor/internal/price"
+)
+
+// Coin is a CoinGecko coins list entry.
+type Coin struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+}
+
+// Default CoinGecko coin IDs for common tickers, some of which are ambiguous in the CoinGecko coins list.
+var defaultIds = map[string]string{
+	"ADA":  "cardano",
+	"BNB":  "binancecoin",
+	"BTC":  "bitcoin",
+	"DAI":  "dai",
+	"DOGE": "dogecoin",
+	"DOT":  "polkadot",
+	"ETH":  "ethereum",
+	"LTC":  "litecoin",
+	"SOL":  "solana",
+	"USDC": "usd-coin",
+	"USDT": "tether",
+	"XRP":  "ripple",
+}
+
+type PriceReader struct {
+	*Context
+	*price.Cache
+	ids   map[string]string // Maps asset symbols to CoinGecko coin IDs
+	coins []Coin            // CoinGecko coins list (fetched on demand)
+	mu    *sync.Mutex       // Guards ids and coins
+}
+
+// NewPriceReader returns a CoinGecko price reader; `ids` maps asset symbols to CoinGecko coin IDs
+// and overrides the default and coins list symbol mappings.
+func NewPriceReader(ctx *Context, ids map[string]string) PriceReader {
+	result := PriceReader{
+		Context: ctx,
+		Cache:   price.NewCache(ctx, filepath.Join(ctx.CacheDir, "coingecko-prices.json")),
+		ids:     make(map[string]string),
+		mu:      &sync.Mutex{},
+	}
+	for k, v := range defaultIds {
+		result.ids[k] = v
+	}
+	for k, v := range ids {
+		result.ids[strings.ToUpper(k)] = v
+	}
+	return result
+}
+
+// Name returns the price source name.
+func (r *PriceReader) Name() string { return "coingecko" }
+
+// httpGetJSON fetches `url` and decodes the JSON response into `v`.
+func (r *PriceReader) httpGetJSON(ctx context.Context, url string, v any) error {
+	resp, err := r.HttpGetWithContext(ctx, url)
+	if err != nil {
+		return fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP response status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("error parsing JSON: %v", err)
+	}
+	return nil
+}
+
+// CoinID returns the CoinGecko coin ID for asset `symbol`.
+// Symbols that are not explicitly mapped are looked up in the CoinGecko coins list.
+func (r *PriceReader) CoinID(ctx context.Context, symbol string) (string, error) {
+	symbol = strings.ToUpper(symbol)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.ids[symbol]; ok {
+		return id, nil
+	}
+	if r.coins == nil {
+		coins := []Coin{}
+		if err := r.httpGetJSON(ctx, COINGECKO_COINS_QUERY, &coins); err != nil {
+			return "", err
+		}
+		r.coins = coins
+	}
+	ids := []string{}
+	for _, c := range r.coins {
+		if strings.ToUpper(c.Symbol) == symbol {
+			ids = append(ids, c.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("unknown CoinGecko symbol: %s", symbol)
+	case 1:
+		r.ids[symbol] = ids[0]
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous CoinGecko symbol: %s: set the coingecko-ids config option to one of: %s", symbol, strings.Join(ids, ", "))
+	}
+}
+
+func (r *PriceReader) getPrice(ctx context.Context, symbol string) (float64, error) {
+	id, err := r.CoinID(ctx, symbol)
+	if err != nil {
+		return 0, err
+	}
+	// The response maps coin IDs to prices e.g. {"bitcoin":{"usd":100000}}
+	var prices map[string]map[string]float64
+	if err := r.httpGetJSON(ctx, COINGECKO_PRICE_QUERY+id, &prices); err != nil {
+		return 0, err
+	}
+	price, ok := prices[id]["usd"]
+	if !ok {
+		return 0, fmt.Errorf("missing CoinGecko price: %s (%s)", symbol, id)
+	}
+	return price, nil
+}
+
+func (r *PriceReader) GetCachedPrice(ctx context.Context, symbol string) (price float64, err error) {
+	var ok bool
+	if price, ok = r.Get(symbol); !ok {
+		if r.Offline {
+			return 0.0, fmt.Errorf("offline: no cached price: %s", strings.ToUpper(symbol))
+		}
+		price, err = r.getPrice(ctx, symbol)
+		if err != nil {
+			return 0.0, err
+		}
+		r.Put(symbol, price)
+	}
+	return
+}
+
+// GetQuote implements the price.PriceSource interface.
+func (r *PriceReader) GetQuote(ctx context.Context, symbol string) (price.Quote, error) {
+	p, err := r.GetCachedPrice(ctx, symbol)
+	if err != nil {
+		return price.Quote{}, err
+	}
+	return price.Quote{Symbol: strings.ToUpper(symbol), Price: p, Source: r.Name(), Stale: r.Offline}, nil
+}
+
+// GetHistoricalQuote implements the price.Historical interface.
+// CoinGecko historical prices are snapshots taken at 00:00 UTC so the closing price on `date` is the
+// snapshot taken at the start of the following day.
+func (r *PriceReader) GetHistoricalQuote(ctx context.Context, symbol string, date time.Time) (price.Quote, error) {
+	symbol = strings.ToUpper(symbol)
+	id, err := r.CoinID(ctx, symbol)
+	if err != nil {
+		return price.Quote{}, err
+	}
+	snapshot := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	var history struct {
+		MarketData struct {
+			CurrentPrice map[string]float64 `json:"current_price"`
+		} `json:"market_data"`
+	}
+	url := COINGECKO_HISTORY_QUERY + id + "/history?localization=false&date=" + snapshot.Format("02-01-2006")
+	if err := r.httpGetJSON(ctx, url, &history); err != nil {
+		return price.Quote{}, err
+	}
+	p, ok := history.MarketData.CurrentPrice["usd"]
+	if !ok {
+		return price.Quote{}, fmt.Errorf("missing CoinGecko price: %s (%s) on %s", symbol, id, date.Format("2006-01-02"))
+	}
+	return price.Quote{Symbol: symbol, Price: p, Source: r.Name()}, nil
+}
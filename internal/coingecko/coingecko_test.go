@@ -0,0 +1,72 @@
+package coingecko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestPrice(t *testing.T) {
+	ctx := mock.NewContext()
+	reader := NewPriceReader(&ctx, map[string]string{"dup": "dup-two"})
+
+	price, err := reader.GetCachedPrice(context.Background(), "BTC")
+	wanted := 100_000.0
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, wanted, price)
+
+	price, err = reader.GetCachedPrice(context.Background(), "usdc")
+	wanted = 1.0
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, wanted, price)
+
+	quote, err := reader.GetQuote(context.Background(), "sml")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "SML", quote.Symbol)
+	assert.Equal(t, 0.5, quote.Price)
+	assert.Equal(t, "coingecko", quote.Source)
+
+	_, err = reader.GetCachedPrice(context.Background(), "INVALID_SYMBOL")
+	assert.Equal(t, "unknown CoinGecko symbol: INVALID_SYMBOL", err.Error())
+
+	_, err = reader.GetCachedPrice(context.Background(), "DUP")
+	assert.Equal(t, "unexpected HTTP response status code: 404", err.Error())
+}
+
+func TestCoinID(t *testing.T) {
+	ctx := mock.NewContext()
+	reader := NewPriceReader(&ctx, map[string]string{"eth": "ethereum-classic"})
+
+	id, err := reader.CoinID(context.Background(), "btc")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "bitcoin", id)
+
+	id, err = reader.CoinID(context.Background(), "ETH")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "ethereum-classic", id)
+
+	id, err = reader.CoinID(context.Background(), "SML")
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "small-coin", id)
+
+	_, err = reader.CoinID(context.Background(), "DUP")
+	assert.Equal(t, "ambiguous CoinGecko symbol: DUP: set the coingecko-ids config option to one of: dup-one, dup-two", err.Error())
+}
+
+func TestHistoricalQuote(t *testing.T) {
+	ctx := mock.NewContext()
+	reader := NewPriceReader(&ctx, nil)
+	date := time.Date(2000, 6, 30, 0, 0, 0, 0, time.UTC)
+
+	quote, err := reader.GetHistoricalQuote(context.Background(), "btc", date)
+	assert.PassIf(t, err == nil, "%#v", err)
+	assert.Equal(t, "BTC", quote.Symbol)
+	assert.Equal(t, 50_000.0, quote.Price)
+
+	_, err = reader.GetHistoricalQuote(context.Background(), "USDC", date)
+	assert.PassIf(t, err != nil, "missing historical price should generate an error")
+	assert.Equal(t, "unexpected HTTP response status code: 404", err.Error())
+}
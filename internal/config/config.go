@@ -3,16 +3,49 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/srackham/go-utils/fsx"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	XratesAppId string `yaml:"xrates-appid"` // https://openexchangerates.org/ app ID
+	XratesAppId     string            `yaml:"xrates-appid"`     // https://openexchangerates.org/ app ID
+	XratesProvider  string            `yaml:"xrates-provider"`  // Exchange rates provider: "frankfurter", "ecb" or "openexchangerates" (defaults to "openexchangerates" if xrates-appid is set, otherwise "frankfurter")
+	XratesMaxAge    *int              `yaml:"xrates-max-age"`   // Maximum age in days of cached exchange rates used when rates cannot be fetched (defaults to 7, 0 disables cached rates)
+	PriceSource     string            `yaml:"price-source"`     // Crypto currency price source name (defaults to "binance")
+	PriceSources    []string          `yaml:"price-sources"`    // Ordered list of fallback price source names (overrides price-source)
+	AssetSources    map[string]string `yaml:"asset-sources"`    // Maps asset symbols to pinned price source names
+	PriceMode       string            `yaml:"price-mode"`       // "fallback" (the default) or "consensus"
+	PriceTolerance  *float64          `yaml:"price-tolerance"`  // Consensus mode maximum percentage deviation from the median price (defaults to 2%)
+	CoingeckoIds    map[string]string `yaml:"coingecko-ids"`    // Maps asset symbols to CoinGecko coin IDs
+	PriceWorkers    int               `yaml:"price-workers"`    // Maximum number of concurrent price requests (defaults to 4)
+	PriceTimeout    time.Duration     `yaml:"price-timeout"`    // Deadline for fetching all prices e.g. "30s" (defaults to 60s)
+	PriceCacheTTL   time.Duration     `yaml:"price-cache-ttl"`  // Maximum age of cached prices e.g. "15m" (defaults to 0, prices are always fetched)
+	Stablecoins     map[string]string `yaml:"stablecoins"`      // Maps stablecoin symbols to pegged fiat currencies (merged with the default stablecoins)
+	StablecoinMode  string            `yaml:"stablecoin-mode"`  // Stablecoin valuation: "market" (the default) or "peg"
+	DepegThreshold  *float64          `yaml:"depeg-threshold"`  // Maximum stablecoin market price percentage deviation from the peg (defaults to 0.5%)
+	LotMethod       string            `yaml:"lot-method"`       // Ledger disposals lot matching method: "fifo" (the default), "lifo", "hifo" or "average"
+	LongTermDays    int               `yaml:"long-term-days"`   // Tax report holding period in days after which gains are long-term (defaults to 365)
+	Alerts          Alerts            `yaml:"alerts"`           // Alert rules evaluated after each valuation
+	ValuationsStore string            `yaml:"valuations-store"` // Saved valuations store: "json", "jsonl", "yaml" or "sqlite" (defaults to "jsonl" if valuations.jsonl exists, otherwise "json")
 }
 
-// The config file is loaded by xrates.getRate to get the exchange rates Web service app ID.
+// Alerts configures alert rules and the notifiers that are sent triggered alerts.
+type Alerts struct {
+	Rules     []string   `yaml:"rules"`     // Alert rules e.g. "BTC price below 50000"
+	Notifiers []Notifier `yaml:"notifiers"` // Alert notifiers (defaults to a stdout notifier)
+}
+
+// Notifier configures an alert notifier.
+type Notifier struct {
+	Type    string `yaml:"type"`    // "stdout", "exec" or "webhook"
+	Command string `yaml:"command"` // exec notifier command
+	URL     string `yaml:"url"`     // webhook notifier URL
+}
+
+// The config file is loaded by xrates.getRate to get the exchange rates Web service app ID
+// and by the cli to get the crypto currency price source.
 func LoadConfig(fileName string) (*Config, error) {
 	if !fsx.FileExists(fileName) {
 		return nil, fmt.Errorf("missing config file: %v", fileName)
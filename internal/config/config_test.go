@@ -14,7 +14,8 @@ func TestLoadConfig_ValidYAML(t *testing.T) {
 		t.Fatalf("failed to create temporary file: %v", err)
 	}
 	defer os.Remove(tmpFile.Name())
-	_, err = tmpFile.WriteString(`xrates-appid: 1234`)
+	_, err = tmpFile.WriteString(`xrates-appid: 1234
+price-source: binance`)
 	if err != nil {
 		t.Fatalf("failed to write to temporary file: %v", err)
 	}
@@ -28,6 +29,7 @@ func TestLoadConfig_ValidYAML(t *testing.T) {
 	// Assert that the config is valid.
 	expectedConfig := Config{
 		XratesAppId: "1234",
+		PriceSource: "binance",
 	}
 
 	if diff := cmp.Diff(expectedConfig, *config); diff != "" {
@@ -1,6 +1,7 @@
 package global
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"time"
@@ -17,8 +18,20 @@ var (
 	// COMMIT is the Git commit hash.
 	COMMIT = "-"
 
-	PRICE_QUERY  = "https://api.binance.com/api/v1/ticker/price?symbol="
-	XRATES_QUERY = "https://openexchangerates.org/api/latest.json?app_id="
+	PRICE_QUERY             = "https://api.binance.com/api/v1/ticker/price?symbol="
+	PRICES_QUERY            = "https://api.binance.com/api/v3/ticker/price?symbols="
+	ALL_PRICES_QUERY        = "https://api.binance.com/api/v3/ticker/price"
+	KLINES_QUERY            = "https://api.binance.com/api/v3/klines?interval=1d&limit=1&symbol="
+	XRATES_QUERY            = "https://openexchangerates.org/api/latest.json?app_id="
+	XRATES_HISTORY_QUERY    = "https://openexchangerates.org/api/historical/"
+	FRANKFURTER_QUERY       = "https://api.frankfurter.dev/v1/"
+	ECB_QUERY               = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+	ECB_HISTORY_QUERY       = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml"
+	COINGECKO_PRICE_QUERY   = "https://api.coingecko.com/api/v3/simple/price?vs_currencies=usd&ids="
+	COINGECKO_COINS_QUERY   = "https://api.coingecko.com/api/v3/coins/list"
+	COINGECKO_HISTORY_QUERY = "https://api.coingecko.com/api/v3/coins/"
+
+	HTTP_TIMEOUT = 30 * time.Second // HttpGetWithContext request timeout
 )
 
 // Application dependency injection container
@@ -30,4 +43,8 @@ type Context struct {
 	ConfigDir string
 	Now       func() time.Time
 	HttpGet   func(url string) (*http.Response, error)
+	// HttpGetWithContext is a context-aware form of HttpGet, the request is cancelled if the context is cancelled or times out.
+	HttpGetWithContext func(ctx context.Context, url string) (*http.Response, error)
+	// HttpPost posts `body` to `url` (used by alert webhooks).
+	HttpPost func(url string, contentType string, body io.Reader) (*http.Response, error)
 }
@@ -0,0 +1,357 @@
+// Portfolio transactions ledger.
+package ledger
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ledger entry types.
+const (
+	BUY      = "buy"      // Asset purchase
+	SELL     = "sell"     // Asset sale
+	TRANSFER = "transfer" // Transfer between the owner's wallets or exchanges (only the fee changes the holdings)
+	FEE      = "fee"      // Fee paid in asset units e.g. a network fee
+	REWARD   = "reward"   // Staking, interest or airdrop income valued at the market price when received
+	DEPOSIT  = "deposit"  // Assets received from outside the ledger
+)
+
+// Amounts smaller than epsilon are treated as zero.
+const epsilon = 1e-9
+
+// Rate returns the amount of fiat `currency` that $1 USD would buy on `date` ("YYYY-MM-DD").
+type Rate func(currency string, date string) (float64, error)
+
+// Entry is a ledger transaction.
+type Entry struct {
+	Date        string  `yaml:"date"`         // Transaction date formatted "YYYY-MM-DD"
+	Type        string  `yaml:"type"`         // Entry type: buy, sell, transfer, fee, reward or deposit
+	Asset       string  `yaml:"asset"`        // Crypto currency symbol
+	Quantity    float64 `yaml:"quantity"`     // Number of asset units
+	Price       float64 `yaml:"price"`        // Asset unit price in the entry currency
+	Currency    string  `yaml:"currency"`     // Price fiat currency (defaults to USD)
+	Fee         float64 `yaml:"fee"`          // Transaction fee
+	FeeCurrency string  `yaml:"fee-currency"` // Fee fiat currency or asset symbol (defaults to the entry currency, transfer fees default to the asset)
+	Notes       string  `yaml:"notes"`        // User notes
+}
+
+// Lot matching methods used to select the acquisition lots consumed by disposals.
+const (
+	FIFO    = "fifo"    // First in, first out
+	LIFO    = "lifo"    // Last in, first out
+	HIFO    = "hifo"    // Highest unit cost first out
+	AVERAGE = "average" // Pooled average unit cost (lots are consumed first in, first out)
+)
+
+// Ledger is a list of portfolio transactions.
+type Ledger []Entry
+
+// Lot is an asset acquisition lot.
+type Lot struct {
+	Asset    string  // Crypto currency symbol
+	Date     string  // Acquisition date formatted "YYYY-MM-DD"
+	Quantity float64 // Number of asset units remaining in the lot
+	Cost     float64 // Cost basis of the remaining units in USD
+}
+
+// Disposal is the part of a sell or fee transaction matched to a single acquisition lot.
+// Fees paid in asset units are disposals with zero proceeds.
+type Disposal struct {
+	Asset    string  `json:"asset"`    // Crypto currency symbol
+	Type     string  `json:"type"`     // Disposal ledger entry type
+	Acquired string  `json:"acquired"` // Lot acquisition date formatted "YYYY-MM-DD"
+	Disposed string  `json:"disposed"` // Disposal date formatted "YYYY-MM-DD"
+	Quantity float64 `json:"quantity"` // Number of asset units disposed
+	Proceeds float64 `json:"proceeds"` // Disposal proceeds net of fiat fees in USD
+	Cost     float64 `json:"cost"`     // Cost basis in USD
+}
+
+type Disposals []Disposal
+
+// Holding is an asset amount and its cost basis derived from a ledger.
+type Holding struct {
+	Asset    string  // Crypto currency symbol
+	Amount   float64 // Number of asset units
+	Cost     float64 // Cost basis in USD
+	Realized float64 // Realized gains in USD
+	Lots     []Lot   // Remaining acquisition lots in acquisition order
+}
+
+type Holdings []Holding
+
+// String returns a short description of the entry used in error messages.
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %s %g %s", e.Date, e.Type, e.Quantity, strings.ToUpper(e.Asset))
+}
+
+// Validate checks the ledger entries.
+func (l Ledger) Validate() error {
+	for _, e := range l {
+		if _, err := time.Parse("2006-01-02", e.Date); err != nil {
+			return fmt.Errorf("invalid ledger entry date: \"%s\"", e.Date)
+		}
+		switch e.Type {
+		case BUY, SELL, TRANSFER, FEE, REWARD, DEPOSIT:
+		default:
+			return fmt.Errorf("invalid ledger entry type: \"%s\"", e.Type)
+		}
+		if e.Asset == "" {
+			return fmt.Errorf("%s: missing ledger entry asset", e)
+		}
+		if e.Quantity <= 0 {
+			return fmt.Errorf("%s: ledger entry quantity must be greater than zero", e)
+		}
+		if e.Price < 0 {
+			return fmt.Errorf("%s: ledger entry price cannot be negative", e)
+		}
+		if e.Fee < 0 {
+			return fmt.Errorf("%s: ledger entry fee cannot be negative", e)
+		}
+	}
+	return nil
+}
+
+// Sorted returns a copy of the ledger sorted by date; entries on the same date retain their order.
+func (l Ledger) Sorted() Ledger {
+	res := append(Ledger{}, l...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Date < res[j].Date
+	})
+	return res
+}
+
+// ValidateMethod checks the lot matching `method` name; a blank name defaults to FIFO.
+func ValidateMethod(method string) error {
+	switch method {
+	case "", FIFO, LIFO, HIFO, AVERAGE:
+		return nil
+	}
+	return fmt.Errorf("invalid lot method: \"%s\"", method)
+}
+
+// Holdings replays the ledger and returns the resulting asset holdings sorted by asset symbol along with the disposals in date order.
+// If the `asOf` date ("YYYY-MM-DD") is not blank then entries dated after it are not replayed.
+// Disposals are matched to acquisition lots using the lot matching `method`.
+// Fiat amounts are converted to USD using the `rate` exchange rate on the entry date.
+func (l Ledger) Holdings(method string, rate Rate, asOf string) (Holdings, Disposals, error) {
+	if err := ValidateMethod(method); err != nil {
+		return nil, nil, err
+	}
+	if err := l.Validate(); err != nil {
+		return nil, nil, err
+	}
+	b := book{method: method, rate: rate, holdings: make(map[string]*Holding)}
+	for _, e := range l.Sorted() {
+		if asOf != "" && e.Date > asOf {
+			break
+		}
+		if err := b.apply(e); err != nil {
+			return nil, nil, err
+		}
+	}
+	res := Holdings{}
+	for _, h := range b.holdings {
+		if h.Amount > epsilon {
+			res = append(res, *h)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Asset < res[j].Asset
+	})
+	return res, b.disposals, nil
+}
+
+// Cost returns the total cost basis of the holdings in USD.
+func (hs Holdings) Cost() float64 {
+	res := 0.0
+	for _, h := range hs {
+		res += h.Cost
+	}
+	return res
+}
+
+// Gain returns the realized gain in USD.
+func (d Disposal) Gain() float64 {
+	return d.Proceeds - d.Cost
+}
+
+// Gains returns the total realized gains in USD.
+func (ds Disposals) Gains() float64 {
+	res := 0.0
+	for _, d := range ds {
+		res += d.Gain()
+	}
+	return res
+}
+
+// toUSD converts `amount` of fiat `currency` to USD at the exchange rate on `date`.
+func toUSD(amount float64, currency string, date string, rate Rate) (float64, error) {
+	if amount == 0 {
+		return 0, nil
+	}
+	r, err := rate(currency, date)
+	if err != nil {
+		return 0, err
+	}
+	if r <= 0.00 {
+		return 0, fmt.Errorf("exchange rate is zero or less: %.2f %s", r, currency)
+	}
+	return amount / r, nil
+}
+
+// book tracks asset lots and disposals while a ledger is replayed.
+type book struct {
+	method    string
+	rate      Rate
+	holdings  map[string]*Holding
+	disposals Disposals
+}
+
+// holding returns the holding of asset `symbol`, creating it if necessary.
+func (b *book) holding(symbol string) *Holding {
+	h, ok := b.holdings[symbol]
+	if !ok {
+		h = &Holding{Asset: symbol}
+		b.holdings[symbol] = h
+	}
+	return h
+}
+
+// acquire adds an acquisition lot to the holding of asset `symbol`.
+func (b *book) acquire(symbol string, date string, quantity float64, cost float64) {
+	h := b.holding(symbol)
+	h.Lots = append(h.Lots, Lot{Asset: symbol, Date: date, Quantity: quantity, Cost: cost})
+	h.Amount += quantity
+	h.Cost += cost
+}
+
+// order returns the indexes of the holding's lots in the order they are consumed by disposals.
+func (b *book) order(h *Holding) []int {
+	res := make([]int, len(h.Lots))
+	for i := range res {
+		res[i] = i
+	}
+	switch b.method {
+	case LIFO:
+		sort.SliceStable(res, func(i, j int) bool {
+			return res[i] > res[j]
+		})
+	case HIFO:
+		unitCost := func(lot Lot) float64 { return lot.Cost / lot.Quantity }
+		sort.SliceStable(res, func(i, j int) bool {
+			return unitCost(h.Lots[res[i]]) > unitCost(h.Lots[res[j]])
+		})
+	}
+	return res
+}
+
+// dispose removes `quantity` units of asset `symbol` from its lots and records a disposal for each matched lot.
+// The `proceeds` (in USD) are apportioned to the matched lots by quantity.
+func (b *book) dispose(e Entry, typ string, symbol string, quantity float64, proceeds float64) error {
+	h := b.holding(symbol)
+	if quantity > h.Amount+epsilon {
+		return fmt.Errorf("%s: insufficient %s holdings: %g", e, symbol, h.Amount)
+	}
+	if b.method == AVERAGE {
+		// Pool the lot costs so that every lot has the average unit cost.
+		for i := range h.Lots {
+			h.Lots[i].Cost = h.Cost * h.Lots[i].Quantity / h.Amount
+		}
+	}
+	remaining := quantity
+	for _, i := range b.order(h) {
+		if remaining <= epsilon {
+			break
+		}
+		lot := &h.Lots[i]
+		q := math.Min(remaining, lot.Quantity)
+		cost := lot.Cost * q / lot.Quantity
+		d := Disposal{Asset: symbol, Type: typ, Acquired: lot.Date, Disposed: e.Date, Quantity: q, Proceeds: proceeds * q / quantity, Cost: cost}
+		b.disposals = append(b.disposals, d)
+		h.Realized += d.Gain()
+		lot.Quantity -= q
+		lot.Cost -= cost
+		remaining -= q
+	}
+	// Drop exhausted lots and recalculate the holding totals.
+	lots := []Lot{}
+	h.Amount, h.Cost = 0, 0
+	for _, lot := range h.Lots {
+		if lot.Quantity > epsilon {
+			lots = append(lots, lot)
+			h.Amount += lot.Quantity
+			h.Cost += lot.Cost
+		}
+	}
+	h.Lots = lots
+	return nil
+}
+
+// apply updates the book with ledger entry `e`.
+// Fiat fees are added to the cost of acquisitions and transfers and are deducted from sale proceeds.
+// Fees paid in the acquired asset reduce the quantity acquired, other fees paid in asset units are zero proceeds disposals.
+func (b *book) apply(e Entry) error {
+	asset := strings.ToUpper(e.Asset)
+	currency := strings.ToUpper(e.Currency)
+	if currency == "" {
+		currency = "USD"
+	}
+	feeCurrency := strings.ToUpper(e.FeeCurrency)
+	if feeCurrency == "" {
+		if e.Type == TRANSFER {
+			feeCurrency = asset
+		} else {
+			feeCurrency = currency
+		}
+	}
+	_, held := b.holdings[feeCurrency]
+	assetFee := e.Fee > 0 && (held || feeCurrency == asset)
+	fiatFee := 0.0
+	if e.Fee > 0 && !assetFee {
+		var err error
+		if fiatFee, err = toUSD(e.Fee, feeCurrency, e.Date, b.rate); err != nil {
+			return fmt.Errorf("%s: fee: %v", e, err)
+		}
+	}
+	amount, err := toUSD(e.Quantity*e.Price, currency, e.Date, b.rate)
+	if err != nil {
+		return fmt.Errorf("%s: %v", e, err)
+	}
+	switch e.Type {
+	case BUY, REWARD, DEPOSIT:
+		quantity := e.Quantity
+		if assetFee && feeCurrency == asset {
+			if e.Fee >= quantity {
+				return fmt.Errorf("%s: fee exceeds quantity: %g", e, e.Fee)
+			}
+			quantity -= e.Fee
+			assetFee = false
+		}
+		b.acquire(asset, e.Date, quantity, amount+fiatFee)
+	case SELL:
+		if err := b.dispose(e, SELL, asset, e.Quantity, amount-fiatFee); err != nil {
+			return err
+		}
+	case FEE:
+		if err := b.dispose(e, FEE, asset, e.Quantity, -fiatFee); err != nil {
+			return err
+		}
+	case TRANSFER:
+		h := b.holding(asset)
+		if e.Quantity > h.Amount+epsilon {
+			return fmt.Errorf("%s: insufficient %s holdings: %g", e, asset, h.Amount)
+		}
+		// Fiat transfer fees are added to the cost of the asset's lots.
+		for i := range h.Lots {
+			h.Lots[i].Cost += fiatFee * h.Lots[i].Quantity / h.Amount
+		}
+		h.Cost += fiatFee
+	}
+	if assetFee {
+		return b.dispose(e, FEE, feeCurrency, e.Fee, 0)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package ledger
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/srackham/go-utils/assert"
+)
+
+func mockRate(currency string, date string) (float64, error) {
+	switch currency {
+	case "USD":
+		return 1.0, nil
+	case "AUD":
+		return 2.0, nil
+	}
+	return 0, fmt.Errorf("unknown currency: %s", currency)
+}
+
+func TestHoldings(t *testing.T) {
+	ledger := Ledger{
+		{Date: "2000-03-01", Type: SELL, Asset: "BTC", Quantity: 0.5, Price: 20000, Fee: 20},
+		{Date: "2000-01-10", Type: BUY, Asset: "btc", Quantity: 1.0, Price: 10000, Fee: 10},
+		{Date: "2000-02-01", Type: BUY, Asset: "ETH", Quantity: 10, Price: 500, Currency: "aud", Fee: 0.01, FeeCurrency: "ETH"},
+		{Date: "2000-03-02", Type: TRANSFER, Asset: "BTC", Quantity: 0.5, Fee: 0.001},
+		{Date: "2000-04-01", Type: REWARD, Asset: "ETH", Quantity: 1.01, Price: 100},
+		{Date: "2000-04-02", Type: FEE, Asset: "ETH", Quantity: 1.0},
+		{Date: "2000-05-01", Type: DEPOSIT, Asset: "USDC", Quantity: 100, Price: 1},
+		{Date: "2000-05-02", Type: BUY, Asset: "SOL", Quantity: 2, Price: 10, Fee: 1, FeeCurrency: "USDC"},
+	}
+	holdings, disposals, err := ledger.Holdings(FIFO, mockRate, "")
+	assert.PassIf(t, err == nil, "%v", err)
+	ethCost := 2601 - 2500/9.99
+	wanted := Holdings{
+		{Asset: "BTC", Amount: 0.499, Cost: 4994.99, Realized: 4975 - 10.01},
+		{Asset: "ETH", Amount: 10, Cost: ethCost, Realized: -2500 / 9.99},
+		{Asset: "SOL", Amount: 2, Cost: 20},
+		{Asset: "USDC", Amount: 99, Cost: 99, Realized: -1},
+	}
+	assert.Equal(t, len(wanted), len(holdings))
+	for i := range wanted {
+		assert.Equal(t, wanted[i].Asset, holdings[i].Asset)
+		assert.PassIf(t, math.Abs(wanted[i].Amount-holdings[i].Amount) < 1e-9, "%v: wanted amount %v, got %v", wanted[i].Asset, wanted[i].Amount, holdings[i].Amount)
+		assert.PassIf(t, math.Abs(wanted[i].Cost-holdings[i].Cost) < 1e-6, "%v: wanted cost %v, got %v", wanted[i].Asset, wanted[i].Cost, holdings[i].Cost)
+		assert.PassIf(t, math.Abs(wanted[i].Realized-holdings[i].Realized) < 1e-6, "%v: wanted realized %v, got %v", wanted[i].Asset, wanted[i].Realized, holdings[i].Realized)
+	}
+	assert.PassIf(t, math.Abs(4994.99+ethCost+20+99-holdings.Cost()) < 1e-6, "unexpected total cost: %v", holdings.Cost())
+	assert.Equal(t, 2, len(holdings[1].Lots))
+	assert.Equal(t, Lot{Asset: "ETH", Date: "2000-04-01", Quantity: 1.01, Cost: 101}, holdings[1].Lots[1])
+	assert.Equal(t, 4, len(disposals))
+	assert.Equal(t, Disposal{Asset: "BTC", Type: SELL, Acquired: "2000-01-10", Disposed: "2000-03-01", Quantity: 0.5, Proceeds: 9980, Cost: 5005}, disposals[0])
+	assert.Equal(t, FEE, disposals[1].Type)
+	assert.Equal(t, 0.0, disposals[1].Proceeds)
+	assert.PassIf(t, math.Abs(4975-10.01-2500/9.99-1-disposals.Gains()) < 1e-6, "unexpected realized gains: %v", disposals.Gains())
+
+	// Selling the entire holding removes the asset.
+	ledger = append(ledger, Entry{Date: "2000-06-01", Type: SELL, Asset: "SOL", Quantity: 2, Price: 15})
+	holdings, disposals, err = ledger.Holdings(FIFO, mockRate, "")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(holdings))
+	assert.Equal(t, 10.0, disposals[len(disposals)-1].Gain())
+
+	// Entries dated after the as-of date are not replayed.
+	holdings, disposals, err = ledger.Holdings(FIFO, mockRate, "2000-03-01")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2, len(holdings))
+	assert.Equal(t, "BTC", holdings[0].Asset)
+	assert.Equal(t, 0.5, holdings[0].Amount)
+	assert.Equal(t, 1, len(disposals))
+	holdings, _, err = ledger.Holdings(FIFO, mockRate, "1999-12-31")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 0, len(holdings))
+}
+
+func TestLotMethods(t *testing.T) {
+	ledger := Ledger{
+		{Date: "2000-01-01", Type: BUY, Asset: "BTC", Quantity: 1, Price: 100},
+		{Date: "2000-02-01", Type: BUY, Asset: "BTC", Quantity: 1, Price: 300},
+		{Date: "2000-03-01", Type: BUY, Asset: "BTC", Quantity: 1, Price: 200},
+		{Date: "2000-04-01", Type: SELL, Asset: "BTC", Quantity: 1.5, Price: 400},
+	}
+	tests := []struct {
+		method   string
+		acquired []string
+		realized float64
+		cost     float64
+	}{
+		{"", []string{"2000-01-01", "2000-02-01"}, 350, 350},
+		{FIFO, []string{"2000-01-01", "2000-02-01"}, 350, 350},
+		{LIFO, []string{"2000-03-01", "2000-02-01"}, 250, 250},
+		{HIFO, []string{"2000-02-01", "2000-03-01"}, 200, 200},
+		{AVERAGE, []string{"2000-01-01", "2000-02-01"}, 300, 300},
+	}
+	for _, tt := range tests {
+		holdings, disposals, err := ledger.Holdings(tt.method, mockRate, "")
+		assert.PassIf(t, err == nil, "%v", err)
+		assert.Equal(t, len(tt.acquired), len(disposals))
+		for i := range tt.acquired {
+			assert.Equal(t, tt.acquired[i], disposals[i].Acquired)
+		}
+		assert.PassIf(t, math.Abs(tt.realized-disposals.Gains()) < 1e-9, "%v: wanted realized %v, got %v", tt.method, tt.realized, disposals.Gains())
+		assert.PassIf(t, math.Abs(tt.realized-holdings[0].Realized) < 1e-9, "%v: wanted realized %v, got %v", tt.method, tt.realized, holdings[0].Realized)
+		assert.PassIf(t, math.Abs(tt.cost-holdings[0].Cost) < 1e-9, "%v: wanted cost %v, got %v", tt.method, tt.cost, holdings[0].Cost)
+		assert.Equal(t, 1.5, holdings[0].Amount)
+	}
+	_, _, err := ledger.Holdings("lofo", mockRate, "")
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `invalid lot method: "lofo"`, err.Error())
+}
+
+func TestLedgerErrors(t *testing.T) {
+	tests := []struct {
+		entry  Entry
+		errMsg string
+	}{
+		{Entry{Date: "01-01-2000", Type: BUY, Asset: "BTC", Quantity: 1}, `invalid ledger entry date: "01-01-2000"`},
+		{Entry{Date: "2000-01-01", Type: "swap", Asset: "BTC", Quantity: 1}, `invalid ledger entry type: "swap"`},
+		{Entry{Date: "2000-01-01", Type: BUY, Quantity: 1}, `2000-01-01 buy 1 : missing ledger entry asset`},
+		{Entry{Date: "2000-01-01", Type: BUY, Asset: "BTC"}, `2000-01-01 buy 0 BTC: ledger entry quantity must be greater than zero`},
+		{Entry{Date: "2000-01-01", Type: BUY, Asset: "BTC", Quantity: 1, Price: -1}, `2000-01-01 buy 1 BTC: ledger entry price cannot be negative`},
+		{Entry{Date: "2000-01-01", Type: SELL, Asset: "BTC", Quantity: 1}, `2000-01-01 sell 1 BTC: insufficient BTC holdings: 0`},
+		{Entry{Date: "2000-01-01", Type: BUY, Asset: "BTC", Quantity: 1, Price: 100, Currency: "XYZ"}, `2000-01-01 buy 1 BTC: unknown currency: XYZ`},
+		{Entry{Date: "2000-01-01", Type: BUY, Asset: "BTC", Quantity: 1, Fee: 2, FeeCurrency: "BTC"}, `2000-01-01 buy 1 BTC: fee exceeds quantity: 2`},
+		{Entry{Date: "2000-01-01", Type: TRANSFER, Asset: "BTC", Quantity: 1}, `2000-01-01 transfer 1 BTC: insufficient BTC holdings: 0`},
+	}
+	for _, tt := range tests {
+		_, _, err := Ledger{tt.entry}.Holdings(FIFO, mockRate, "")
+		assert.PassIf(t, err != nil, "%v: error expected", tt.entry)
+		assert.EqualStrings(t, tt.errMsg, err.Error())
+	}
+}
@@ -0,0 +1,149 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Holding period terms.
+const (
+	SHORT_TERM = "short"
+	LONG_TERM  = "long"
+)
+
+// TaxEvent is a disposal reported in a capital gains tax report.
+// Fiat amounts are denominated in the report currency.
+type TaxEvent struct {
+	Portfolio string  `yaml:"portfolio" json:"portfolio"` // Portfolio name
+	Asset     string  `yaml:"asset"     json:"asset"`     // Crypto currency symbol
+	Type      string  `yaml:"type"      json:"type"`      // Disposal ledger entry type
+	Quantity  float64 `yaml:"quantity"  json:"quantity"`  // Number of asset units disposed
+	Acquired  string  `yaml:"acquired"  json:"acquired"`  // Acquisition date formatted "YYYY-MM-DD"
+	Disposed  string  `yaml:"disposed"  json:"disposed"`  // Disposal date formatted "YYYY-MM-DD"
+	Proceeds  float64 `yaml:"proceeds"  json:"proceeds"`  // Proceeds converted at the disposal date exchange rate
+	Cost      float64 `yaml:"cost"      json:"cost"`      // Cost basis converted at the acquisition date exchange rate
+	Gain      float64 `yaml:"gain"      json:"gain"`      // Proceeds less cost basis
+	Term      string  `yaml:"term"      json:"term"`      // Holding period term: "short" or "long"
+	Currency  string  `yaml:"currency"  json:"currency"`  // Report currency
+}
+
+// TaxReport is a list of tax events sorted by disposal date.
+type TaxReport []TaxEvent
+
+// TaxEvents returns the `disposals` of portfolio `name` that occurred during `year`.
+// Disposals held for more than `longTermDays` days are long-term.
+// USD amounts are converted to fiat `currency` at the `rate` exchange rates on the acquisition and disposal dates.
+// Fees paid in asset units (e.g. network fees) are "fee" type disposals with zero proceeds, so they are reported
+// as capital losses of their cost basis.
+// The disposals must not be matched with the AVERAGE lot method: pooled disposals are matched to lots first in,
+// first out, so their acquisition dates (which determine the term and the cost exchange rate) are not meaningful.
+func TaxEvents(name string, disposals Disposals, year int, longTermDays int, currency string, rate Rate) (TaxReport, error) {
+	res := TaxReport{}
+	for _, d := range disposals {
+		disposed, err := time.Parse("2006-01-02", d.Disposed)
+		if err != nil {
+			return nil, err
+		}
+		if disposed.Year() != year {
+			continue
+		}
+		acquired, err := time.Parse("2006-01-02", d.Acquired)
+		if err != nil {
+			return nil, err
+		}
+		disposalRate, err := rate(currency, d.Disposed)
+		if err != nil {
+			return nil, err
+		}
+		acquisitionRate, err := rate(currency, d.Acquired)
+		if err != nil {
+			return nil, err
+		}
+		e := TaxEvent{
+			Portfolio: name,
+			Asset:     d.Asset,
+			Type:      d.Type,
+			Quantity:  d.Quantity,
+			Acquired:  d.Acquired,
+			Disposed:  d.Disposed,
+			Proceeds:  d.Proceeds * disposalRate,
+			Cost:      d.Cost * acquisitionRate,
+			Term:      SHORT_TERM,
+			Currency:  currency,
+		}
+		e.Gain = e.Proceeds - e.Cost
+		if disposed.Sub(acquired).Hours()/24 > float64(longTermDays) {
+			e.Term = LONG_TERM
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// Sort sorts the report by disposal date; events on the same date retain their order.
+func (r TaxReport) Sort() {
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Disposed < r[j].Disposed
+	})
+}
+
+// Gains returns the total short-term and long-term gains.
+func (r TaxReport) Gains() (short float64, long float64) {
+	for _, e := range r {
+		if e.Term == LONG_TERM {
+			long += e.Gain
+		} else {
+			short += e.Gain
+		}
+	}
+	return
+}
+
+// ToText returns the report formatted as text; `currency` is the report currency.
+func (r TaxReport) ToText(year int, currency string) string {
+	short, long := r.Gains()
+	res := fmt.Sprintf("YEAR:       %d\nSHORT-TERM: %.2f %s\nLONG-TERM:  %.2f %s\nTOTAL:      %.2f %s\n",
+		year, short, currency, long, currency, short+long, currency)
+	res += "PORTFOLIO        ASSET TYPE        QUANTITY ACQUIRED   DISPOSED           PROCEEDS             COST             GAIN TERM\n"
+	for _, e := range r {
+		res += fmt.Sprintf("%-16s %-5s %-8s %11.4f %s %s %12.2f %s %12.2f %s %12.2f %s %s\n",
+			e.Portfolio, e.Asset, e.Type, e.Quantity, e.Acquired, e.Disposed,
+			e.Proceeds, currency, e.Cost, currency, e.Gain, currency, e.Term)
+	}
+	return res
+}
+
+// ToCSV returns the report formatted as CSV with a header row.
+func (r TaxReport) ToCSV() (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	f := func(v float64, prec int) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	if err := w.Write([]string{"portfolio", "asset", "type", "quantity", "acquired", "disposed", "proceeds", "cost", "gain", "term", "currency"}); err != nil {
+		return "", err
+	}
+	for _, e := range r {
+		err := w.Write([]string{e.Portfolio, e.Asset, e.Type, f(e.Quantity, -1), e.Acquired, e.Disposed, f(e.Proceeds, 2), f(e.Cost, 2), f(e.Gain, 2), e.Term, e.Currency})
+		if err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+func (r TaxReport) ToJSON() (string, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	return string(data) + "\n", err
+}
+
+func (r TaxReport) ToYAML() (string, error) {
+	data, err := yaml.Marshal(r)
+	return string(data), err
+}
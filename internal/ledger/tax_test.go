@@ -0,0 +1,31 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestTaxEvents(t *testing.T) {
+	disposals := Disposals{
+		{Asset: "BTC", Type: SELL, Acquired: "1999-01-01", Disposed: "2000-01-01", Quantity: 1, Proceeds: 300, Cost: 100},
+		{Asset: "BTC", Type: SELL, Acquired: "1999-01-01", Disposed: "2000-01-02", Quantity: 1, Proceeds: 300, Cost: 100},
+		{Asset: "ETH", Type: FEE, Acquired: "2000-03-01", Disposed: "2000-04-01", Quantity: 0.1, Cost: 10},
+		{Asset: "ETH", Type: SELL, Acquired: "2000-03-01", Disposed: "2001-04-01", Quantity: 1, Proceeds: 50, Cost: 100},
+	}
+	report, err := TaxEvents("portfolio1", disposals, 2000, 365, "AUD", mockRate)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(report))
+	assert.Equal(t, SHORT_TERM, report[0].Term) // Held for 365 days
+	assert.Equal(t, LONG_TERM, report[1].Term)  // Held for 366 days
+	assert.Equal(t, TaxEvent{Portfolio: "portfolio1", Asset: "BTC", Type: SELL, Quantity: 1, Acquired: "1999-01-01", Disposed: "2000-01-02",
+		Proceeds: 600, Cost: 200, Gain: 400, Term: LONG_TERM, Currency: "AUD"}, report[1])
+	assert.Equal(t, -20.0, report[2].Gain)
+	short, long := report.Gains()
+	assert.Equal(t, 380.0, short)
+	assert.Equal(t, 400.0, long)
+
+	_, err = TaxEvents("portfolio1", disposals, 2000, 365, "XYZ", mockRate)
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, "unknown currency: XYZ", err.Error())
+}
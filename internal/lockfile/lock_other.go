@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package lockfile
+
+import "os"
+
+// File locking is not supported on this platform, locks are no-ops.
+
+func lock(f *os.File) error { return nil }
+
+func unlock(f *os.File) error { return nil }
@@ -0,0 +1,129 @@
+// Advisory file locks and atomic file writes.
+package lockfile
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Lock is an exclusive advisory lock on a file.
+// The lock is held on a separate lock file (the locked file name with a .lock extension) so that the locked
+// file can be replaced while the lock is held; lock files are not deleted.
+type Lock struct {
+	f *os.File
+}
+
+// New acquires an exclusive lock on file `fname`, blocking until the lock is available.
+// The file's directory is created if it does not exist.
+func New(fname string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fname+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// WriteFile atomically replaces the contents of file `fname` with `data`.
+// The data is written to a temporary file which is renamed to `fname`; the previous version of the file
+// is kept in a backup file (the file name with a .bak extension) which is replaced by each write.
+func WriteFile(fname string, data []byte) (err error) {
+	dir := filepath.Dir(fname)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(fname)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if _, statErr := os.Stat(fname); statErr == nil {
+		if err = backup(fname); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), fname)
+}
+
+// Update locks file `fname` and replaces its contents with the data returned by `fn`.
+// `fn` is passed the current file contents (nil if the file does not exist); the file is only written
+// (see WriteFile) if the contents have changed.
+func Update(fname string, fn func(data []byte) ([]byte, error)) error {
+	l, err := New(fname)
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	data, err := os.ReadFile(fname)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	updated, err := fn(data)
+	if err != nil {
+		return err
+	}
+	if data != nil && bytes.Equal(data, updated) {
+		return nil
+	}
+	return WriteFile(fname, updated)
+}
+
+// backup replaces the backup copy of file `fname`.
+// The backup is a hard link to the file (or a copy if the file system does not support hard links).
+func backup(fname string) error {
+	bak := fname + ".bak"
+	if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.Link(fname, bak) == nil {
+		return nil
+	}
+	src, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(bak)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,63 @@
+package lockfile
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	fname := filepath.Join(tmpdir, "data", "valuations.json")
+	err := WriteFile(fname, []byte("one"))
+	assert.PassIf(t, err == nil, "%v", err)
+	_, err = os.Stat(fname + ".bak")
+	assert.PassIf(t, os.IsNotExist(err), "unexpected backup file")
+	for _, s := range []string{"two", "three"} {
+		err = WriteFile(fname, []byte(s))
+		assert.PassIf(t, err == nil, "%v", err)
+	}
+	data, err := os.ReadFile(fname)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.EqualStrings(t, "three", string(data))
+	data, err = os.ReadFile(fname + ".bak")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.EqualStrings(t, "two", string(data))
+	// Temporary files are not left behind.
+	matches, _ := filepath.Glob(filepath.Join(tmpdir, "data", "*.tmp*"))
+	assert.Equal(t, 0, len(matches))
+}
+
+func TestUpdate(t *testing.T) {
+	tmpdir := mock.MkdirTemp(t)
+	fname := filepath.Join(tmpdir, "counter")
+	// Concurrent read-modify-write cycles are serialized by the lock.
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(fname, func(data []byte) ([]byte, error) {
+				n, _ := strconv.Atoi(string(data))
+				return []byte(strconv.Itoa(n + 1)), nil
+			})
+			assert.PassIf(t, err == nil, "%v", err)
+		}()
+	}
+	wg.Wait()
+	data, err := os.ReadFile(fname)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.EqualStrings(t, "20", string(data))
+
+	// The file is not written if it is unchanged.
+	os.Remove(fname + ".bak")
+	err = Update(fname, func(data []byte) ([]byte, error) { return data, nil })
+	assert.PassIf(t, err == nil, "%v", err)
+	_, err = os.Stat(fname + ".bak")
+	assert.PassIf(t, os.IsNotExist(err), "unexpected backup file")
+}
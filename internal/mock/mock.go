@@ -2,10 +2,15 @@ package mock
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -35,6 +40,13 @@ func NewContext() Context {
 		ConfigDir: "../../testdata",
 		Now:       now,
 		HttpGet:   httpGet,
+		HttpGetWithContext: func(ctx context.Context, url string) (*http.Response, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return httpGet(url)
+		},
+		HttpPost: http.Post, // Posts to local test servers
 	}
 }
 
@@ -45,8 +57,121 @@ func now() time.Time {
 	return t
 }
 
+// Binance ticker prices.
+var tickers = map[string]string{
+	"BTCUSDT":   "100000.00000000",
+	"ETHUSDT":   "1000.00",
+	"USDCUSDT":  "1.00",
+	"FDUSDUSDT": "1.00",
+}
+
+// allTickersResponse mocks the Binance all symbols ticker price response.
+func allTickersResponse() *http.Response {
+	pairs := []string{}
+	for pair := range tickers {
+		pairs = append(pairs, pair)
+	}
+	sort.Strings(pairs)
+	items := []string{}
+	for _, pair := range pairs {
+		items = append(items, fmt.Sprintf(`{"symbol":"%s","price":"%s"}`, pair, tickers[pair]))
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("[" + strings.Join(items, ",") + "]")),
+	}
+}
+
+// multiTickerResponse mocks the Binance multi-symbol ticker price response.
+func multiTickerResponse(query string) *http.Response {
+	badRequest := &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Body:       io.NopCloser(strings.NewReader(`{"code":-1121,"msg":"Invalid symbol."}`)),
+	}
+	s, err := url.QueryUnescape(query)
+	if err != nil {
+		return badRequest
+	}
+	var pairs []string
+	if err := json.Unmarshal([]byte(s), &pairs); err != nil {
+		return badRequest
+	}
+	items := []string{}
+	for _, pair := range pairs {
+		price, ok := tickers[pair]
+		if !ok {
+			return badRequest
+		}
+		items = append(items, fmt.Sprintf(`{"symbol":"%s","price":"%s"}`, pair, price))
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("[" + strings.Join(items, ",") + "]")),
+	}
+}
+
+// Binance daily kline closing prices (the same for every date).
+var klines = map[string]string{
+	"BTCUSDT":   "50000.00000000",
+	"ETHUSDT":   "500.00000000",
+	"USDCUSDT":  "1.00000000",
+	"FDUSDUSDT": "1.00000000",
+	"XYZBTC":    "0.00020000",
+}
+
+// klinesResponse mocks the Binance klines (candlesticks) response.
+func klinesResponse(query string) *http.Response {
+	pair, startTime, _ := strings.Cut(query, "&startTime=")
+	price, ok := klines[pair]
+	if !ok {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader(`{"code":-1121,"msg":"Invalid symbol."}`)),
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(fmt.Sprintf(`[[%s,"1.0","1.0","1.0","%s","100.0",%s,"100.0",10,"50.0","50.0","0"]]`,
+			startTime, price, startTime))),
+	}
+}
+
+// CoinGecko historical prices (the same for every date).
+var coingeckoHistory = map[string]float64{
+	"bitcoin":    50000,
+	"ethereum":   500,
+	"small-coin": 0.25,
+}
+
+// coingeckoHistoryResponse mocks the CoinGecko coin history response.
+func coingeckoHistoryResponse(query string) *http.Response {
+	id, _, _ := strings.Cut(query, "/history?")
+	price, ok := coingeckoHistory[id]
+	if !ok {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Body:       io.NopCloser(strings.NewReader(`{"error":"coin not found"}`)),
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"id":"%s","market_data":{"current_price":{"usd":%v}}}`, id, price))),
+	}
+}
+
 func httpGet(url string) (resp *http.Response, err error) {
+	if query, ok := strings.CutPrefix(url, PRICES_QUERY); ok {
+		return multiTickerResponse(query), nil
+	}
+	if query, ok := strings.CutPrefix(url, KLINES_QUERY); ok {
+		return klinesResponse(query), nil
+	}
+	if query, ok := strings.CutPrefix(url, COINGECKO_HISTORY_QUERY); ok && query != "list" {
+		return coingeckoHistoryResponse(query), nil
+	}
 	switch url {
+	case ALL_PRICES_QUERY:
+		return allTickersResponse(), nil
 	case PRICE_QUERY + "BTC" + "USDT":
 		return &http.Response{
 			StatusCode: http.StatusOK,
@@ -62,6 +187,16 @@ func httpGet(url string) (resp *http.Response, err error) {
 			StatusCode: http.StatusOK,
 			Body:       io.NopCloser(strings.NewReader(`{"symbol":"USDCUSDT","price":"1.00"}`)),
 		}, nil
+	case PRICE_QUERY + "FDUSD" + "USDT":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"symbol":"FDUSDUSDT","price":"1.00"}`)),
+		}, nil
+	case PRICE_QUERY + "XYZ" + "BTC":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"symbol":"XYZBTC","price":"0.00010000"}`)),
+		}, nil
 	case PRICE_QUERY + "BAD_JSON" + "USDT":
 		return &http.Response{
 			StatusCode: http.StatusOK,
@@ -78,12 +213,116 @@ func httpGet(url string) (resp *http.Response, err error) {
 			Body: io.NopCloser(strings.NewReader(`{
   "rates": {
     "AUD": 1.6,
+    "EUR": 0.8,
     "NZD": 1.5,
     "USD": 1
   }
 }`)),
 		}, nil
+	case XRATES_HISTORY_QUERY + "2000-06-30.json?app_id=1234":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`{
+  "rates": {
+    "AUD": 2.0,
+    "EUR": 0.8,
+    "NZD": 2.5,
+    "USD": 1
+  }
+}`)),
+		}, nil
+	case FRANKFURTER_QUERY + "latest?from=USD":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"amount":1.0,"base":"USD","date":"2000-12-01","rates":{"AUD":1.6,"EUR":0.8,"NZD":1.5}}`)),
+		}, nil
+	case FRANKFURTER_QUERY + "2000-06-30?from=USD":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"amount":1.0,"base":"USD","date":"2000-06-30","rates":{"AUD":2.0,"EUR":0.8,"NZD":2.5}}`)),
+		}, nil
+	case ECB_QUERY:
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2000-12-01">
+			<Cube currency="USD" rate="1.25"/>
+			<Cube currency="AUD" rate="2.0"/>
+			<Cube currency="NZD" rate="1.875"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`)),
+		}, nil
+	case ECB_HISTORY_QUERY:
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2000-12-01">
+			<Cube currency="USD" rate="1.25"/>
+			<Cube currency="AUD" rate="2.0"/>
+			<Cube currency="NZD" rate="1.875"/>
+		</Cube>
+		<Cube time="2000-06-30">
+			<Cube currency="USD" rate="1.0"/>
+			<Cube currency="AUD" rate="2.0"/>
+			<Cube currency="NZD" rate="2.5"/>
+		</Cube>
+		<Cube time="2000-06-29">
+			<Cube currency="USD" rate="1.0"/>
+			<Cube currency="AUD" rate="1.9"/>
+			<Cube currency="NZD" rate="2.4"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`)),
+		}, nil
+	case COINGECKO_PRICE_QUERY + "bitcoin":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"bitcoin":{"usd":100000}}`)),
+		}, nil
+	case COINGECKO_PRICE_QUERY + "ethereum":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"ethereum":{"usd":1000}}`)),
+		}, nil
+	case COINGECKO_PRICE_QUERY + "usd-coin":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"usd-coin":{"usd":1}}`)),
+		}, nil
+	case COINGECKO_PRICE_QUERY + "dai":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"dai":{"usd":0.95}}`)),
+		}, nil
+	case COINGECKO_PRICE_QUERY + "small-coin":
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"small-coin":{"usd":0.5}}`)),
+		}, nil
+	case COINGECKO_COINS_QUERY:
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`[
+  {"id":"small-coin","symbol":"sml","name":"Small Coin"},
+  {"id":"dup-one","symbol":"dup","name":"Duplicate One"},
+  {"id":"dup-two","symbol":"dup","name":"Duplicate Two"}
+]`)),
+		}, nil
 	default:
+		if strings.HasPrefix(url, PRICE_QUERY) {
+			// Binance responds to unknown trading pairs with a 400 status code.
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       io.NopCloser(strings.NewReader(`{"code":-1121,"msg":"Invalid symbol."}`)),
+			}, nil
+		}
 		return &http.Response{
 			StatusCode: http.StatusNotFound,
 			Body:       io.NopCloser(strings.NewReader(`not found`)),
@@ -21,13 +21,23 @@ func TestHttpGet(t *testing.T) {
 		{"Valid USDC query", PRICE_QUERY + "USDC" + "USDT", http.StatusOK, `{"symbol":"USDCUSDT","price":"1.00"}`},
 		{"Bad JSON response", PRICE_QUERY + "BAD_JSON" + "USDT", http.StatusOK, ``},
 		{"Invalid symbol", PRICE_QUERY + "INVALID_SYMBOL" + "USDT", http.StatusBadRequest, ``},
+		{"Cross quote query", PRICE_QUERY + "XYZ" + "BTC", http.StatusOK, `{"symbol":"XYZBTC","price":"0.00010000"}`},
+		{"Unknown trading pair", PRICE_QUERY + "XYZ" + "USDT", http.StatusBadRequest, `{"code":-1121,"msg":"Invalid symbol."}`},
 		{"Valid exchange rate query", XRATES_QUERY + "1234", http.StatusOK, `{
   "rates": {
     "AUD": 1.6,
+    "EUR": 0.8,
     "NZD": 1.5,
     "USD": 1
   }
 }`},
+		{"Valid CoinGecko query", COINGECKO_PRICE_QUERY + "bitcoin", http.StatusOK, `{"bitcoin":{"usd":100000}}`},
+		{"Valid multi-symbol query", PRICES_QUERY + `%5B%22BTCUSDT%22%2C%22ETHUSDT%22%5D`, http.StatusOK, `[{"symbol":"BTCUSDT","price":"100000.00000000"},{"symbol":"ETHUSDT","price":"1000.00"}]`},
+		{"Invalid multi-symbol query", PRICES_QUERY + `%5B%22BTCUSDT%22%2C%22XYZUSDT%22%5D`, http.StatusBadRequest, `{"code":-1121,"msg":"Invalid symbol."}`},
+		{"Valid klines query", KLINES_QUERY + "BTCUSDT&startTime=962323200000", http.StatusOK, `[[962323200000,"1.0","1.0","1.0","50000.00000000","100.0",962323200000,"100.0",10,"50.0","50.0","0"]]`},
+		{"Valid CoinGecko history query", COINGECKO_HISTORY_QUERY + "bitcoin/history?localization=false&date=01-07-2000", http.StatusOK, `{"id":"bitcoin","market_data":{"current_price":{"usd":50000}}}`},
+		{"Valid historical exchange rate query", XRATES_HISTORY_QUERY + "2000-06-30.json?app_id=1234", http.StatusOK, `{"rates":{"AUD":2.0,"EUR":0.8,"NZD":2.5,"USD":1}}`},
+		{"Valid Frankfurter query", FRANKFURTER_QUERY + "latest?from=USD", http.StatusOK, `{"amount":1.0,"base":"USD","date":"2000-12-01","rates":{"AUD":1.6,"EUR":0.8,"NZD":1.5}}`},
 		{"Unknown URL", "https://unknown.com", http.StatusNotFound, `not found`},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,44 @@
+package portfolio
+
+import (
+	"fmt"
+	"math"
+)
+
+// Drift is a portfolio target whose current allocation is outside the portfolio's drift band.
+type Drift struct {
+	Portfolio string  // Portfolio name
+	Target    string  // Target name (an untargeted asset's symbol if the asset is not targeted)
+	Current   float64 // Current allocation percentage
+	Percent   float64 // Target allocation percentage (zero if the asset is not targeted)
+	Band      float64 // Drift band in percentage points
+}
+
+type Drifts []Drift
+
+// String returns the drift formatted as a one line description.
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: %s allocation %.2f%% is outside the %.2f%% ±%.2f%% target band", d.Portfolio, d.Target, d.Current, d.Percent, d.Band)
+}
+
+// Drifts returns the valuated portfolio's targets whose current allocations differ from their target allocations by more
+// than the portfolio's drift band. Held assets that are not targeted have a target allocation of zero.
+// No drifts are returned if the portfolio has no targets or no drift band.
+func (p Portfolio) Drifts() Drifts {
+	res := Drifts{}
+	if len(p.Targets) == 0 || p.DriftBand <= 0 {
+		return res
+	}
+	allocations := p.Allocations()
+	for _, t := range p.Targets {
+		if math.Abs(allocations[t.Name]-t.Percent) > p.DriftBand {
+			res = append(res, Drift{Portfolio: p.Name, Target: t.Name, Current: allocations[t.Name], Percent: t.Percent, Band: p.DriftBand})
+		}
+	}
+	for _, a := range p.Assets {
+		if p.Targets.Find(a.Symbol) == -1 && a.Allocation > p.DriftBand {
+			res = append(res, Drift{Portfolio: p.Name, Target: a.Symbol, Current: a.Allocation, Band: p.DriftBand})
+		}
+	}
+	return res
+}
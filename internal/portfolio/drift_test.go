@@ -0,0 +1,34 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestPortfolio_Drifts(t *testing.T) {
+	targets, err := NewTargets(map[string]float64{"BTC": 70, "ETH": 20, "stable": 10}, map[string][]string{"stable": {"USDC", "USDT"}})
+	assert.PassIf(t, err == nil, "%v", err)
+	p := valuated(Assets{
+		{Symbol: "BTC", Amount: 0.6, Price: 100000},
+		{Symbol: "ETH", Amount: 20, Price: 1000},
+		{Symbol: "USDC", Amount: 8000, Price: 1},
+		{Symbol: "DOGE", Amount: 120000, Price: 0.1},
+	}, targets)
+	// No drift band.
+	assert.Equal(t, 0, len(p.Drifts()))
+
+	p.DriftBand = 5
+	drifts := p.Drifts()
+	assert.Equal(t, 2, len(drifts))
+	assert.Equal(t, "BTC", drifts[0].Target)
+	assert.PassIf(t, math.Abs(60-drifts[0].Current) < 1e-9, "unexpected current allocation: %v", drifts[0].Current)
+	assert.Equal(t, 70.0, drifts[0].Percent)
+	assert.Equal(t, "DOGE", drifts[1].Target)
+	assert.Equal(t, 0.0, drifts[1].Percent)
+	assert.EqualStrings(t, "portfolio1: BTC allocation 60.00% is outside the 70.00% ±5.00% target band", drifts[0].String())
+
+	p.DriftBand = 12.5
+	assert.Equal(t, 0, len(p.Drifts()))
+}
@@ -1,15 +1,22 @@
 package portfolio
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/srackham/cryptor/internal/binance"
+	"github.com/srackham/cryptor/internal/lockfile"
+	"github.com/srackham/cryptor/internal/price"
 	"github.com/srackham/go-utils/fsx"
 	"github.com/srackham/go-utils/helpers"
 	"github.com/srackham/go-utils/set"
@@ -20,11 +27,17 @@ type Prices map[string]float64 // Maps asset symbols to prices
 
 // An amount of crypto currency belonging to a portfolio.
 type Asset struct {
-	Symbol     string  `yaml:"symbol"     json:"symbol"`     // Crypto currecy symbol
-	Price      float64 `yaml:"price"      json:"price"`      // The price in USD at the time of valuation of one asset unit
-	Amount     float64 `yaml:"amount"     json:"amount"`     // Number of asset units
-	Value      float64 `yaml:"value"      json:"value"`      // Asset value in USD at the time of valuation
-	Allocation float64 `yaml:"allocation" json:"allocation"` // Percentage of total portfolio value
+	Symbol     string  `yaml:"symbol"              json:"symbol"`              // Crypto currecy symbol
+	Price      float64 `yaml:"price"               json:"price"`               // The price in USD at the time of valuation of one asset unit
+	Spread     float64 `yaml:"spread,omitempty"    json:"spread,omitempty"`    // Consensus price percentage spread between price sources
+	Route      string  `yaml:"route,omitempty"     json:"route,omitempty"`     // Trading pairs used to derive the price (derived prices only)
+	Amount     float64 `yaml:"amount"              json:"amount"`              // Number of asset units
+	Value      float64 `yaml:"value"               json:"value"`               // Asset value in USD at the time of valuation
+	Allocation float64 `yaml:"allocation"          json:"allocation"`          // Percentage of total portfolio value
+	Cost       float64 `yaml:"cost,omitempty"      json:"cost,omitempty"`      // Cost basis in USD (from the asset's configured cost or its ledger acquisition lots)
+	Gains      float64 `yaml:"gains,omitempty"     json:"gains,omitempty"`     // Unrealized gains in USD (value less cost)
+	GainsPct   float64 `yaml:"gains_pct,omitempty" json:"gains_pct,omitempty"` // Unrealized gains as a percentage of cost
+	Realized   float64 `yaml:"realized,omitempty"  json:"realized,omitempty"`  // Realized gains in USD from disposals of the asset (ledger portfolios only)
 }
 
 type Assets []Asset
@@ -35,13 +48,22 @@ type Assets []Asset
 // - The valuated `Portfolio` is appended to a `valuations.yaml` file.
 // - Note that the portfolios configuration and valuations files have different formats.
 type Portfolio struct {
-	Name   string  `yaml:"name"     json:"name"`  // Porfolio name
-	Notes  string  `yaml:"notes"    json:"notes"` // User notes
-	Date   string  `yaml:"date"     json:"date"`  // The valuation date formatted "YYYY-MM-DD"
-	Time   string  `yaml:"time"     json:"time"`  // The valuation time formatted "hh:mm:ss""
-	Value  float64 `yaml:"value"    json:"value"` // Current portfolio value in USD
-	Cost   float64 `yaml:"cost"     json:"cost"`  // The amount paid for the portfolio calculated in USD at the current exchange rate
-	Assets Assets  `yaml:"assets"   json:"assets"`
+	Name         string  `yaml:"name"                    json:"name"`                    // Porfolio name
+	Notes        string  `yaml:"notes"                   json:"notes"`                   // User notes
+	Date         string  `yaml:"date"                    json:"date"`                    // The valuation date formatted "YYYY-MM-DD"
+	Time         string  `yaml:"time"                    json:"time"`                    // The valuation time formatted "hh:mm:ss""
+	Value        float64 `yaml:"value"                   json:"value"`                   // Current portfolio value in USD
+	Cost         float64 `yaml:"cost"                    json:"cost"`                    // The amount paid for the portfolio in USD calculated at the cost date (or current) exchange rate
+	CostAmount   float64 `yaml:"cost_amount,omitempty"   json:"cost_amount,omitempty"`   // The amount paid for the portfolio in the cost currency
+	CostCurrency string  `yaml:"cost_currency,omitempty" json:"cost_currency,omitempty"` // The currency the portfolio was paid for in
+	CostDate     string  `yaml:"cost_date,omitempty"     json:"cost_date,omitempty"`     // The acquisition date formatted "YYYY-MM-DD" used to convert the cost to USD
+	FXGains      float64 `yaml:"fx_gains,omitempty"      json:"fx_gains,omitempty"`      // Cost currency exchange rate gains in USD since the cost date
+	Realized     float64 `yaml:"realized,omitempty"      json:"realized,omitempty"`      // Realized gains in USD from ledger disposals
+	Stale        bool    `yaml:"stale,omitempty"         json:"stale,omitempty"`         // True if the valuation used offline cached prices
+	Synthesized  bool    `yaml:"synthesized,omitempty"   json:"synthesized,omitempty"`   // True if the valuation was reconstructed by the backfill command
+	Assets       Assets  `yaml:"assets"                  json:"assets"`
+	Targets      Targets `yaml:"-"                       json:"-"` // Target allocations (loaded from the portfolios configuration file)
+	DriftBand    float64 `yaml:"-"                       json:"-"` // Target allocation drift band in percentage points (loaded from the portfolios configuration file)
 }
 
 type Portfolios []Portfolio
@@ -100,15 +122,19 @@ func (assets Assets) Find(symbol string) int {
 }
 
 // SetUSDValues calculates the current USD value of portfolio assets and their total value.
-func (p *Portfolio) SetUSDValues(reader *binance.PriceReader) error {
+// Assets that have not been priced are priced using the `source` price source.
+func (p *Portfolio) SetUSDValues(ctx context.Context, source price.PriceSource) error {
 	total := 0.0
 	for i, a := range p.Assets {
 		if a.Price == 0 {
-			price, err := reader.GetCachedPrice(a.Symbol)
+			quote, err := source.GetQuote(ctx, a.Symbol)
 			if err != nil {
 				return err
 			}
-			a.Price = price
+			a.Price = quote.Price
+			p.Assets[i].Spread = quote.Spread
+			p.Assets[i].Route = quote.Route
+			p.Stale = p.Stale || quote.Stale
 		}
 		val := a.Amount * a.Price
 		p.Assets[i].Value = val
@@ -128,6 +154,16 @@ func (p *Portfolio) SetAllocations() {
 	}
 }
 
+// SetGains synthesizes the unrealized gains of assets that have a cost basis.
+func (p *Portfolio) SetGains() {
+	for i, a := range p.Assets {
+		if a.Cost != 0.00 {
+			p.Assets[i].Gains = a.Value - a.Cost
+			p.Assets[i].GainsPct = p.Assets[i].Gains / a.Cost * 100
+		}
+	}
+}
+
 // See [How to deep copy a struct in Go](https://www.educative.io/answers/how-to-deep-copy-a-struct-in-go)
 func (p Portfolio) DeepCopy() Portfolio {
 	res := p
@@ -141,44 +177,122 @@ func (ps Portfolios) ToJSON() (string, error) {
 	return string(data) + "\n", err
 }
 
+// ToJSONL returns the portfolios formatted as JSON Lines (one portfolio per line).
+func (ps Portfolios) ToJSONL() (string, error) {
+	var b strings.Builder
+	for _, p := range ps {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return "", err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
 func (ps Portfolios) ToYAML() (string, error) {
 	data, err := yaml.Marshal(ps)
 	return string(data), err
 }
 
-// LoadValuations reads a file of portfolio valuations.
+// LoadValuations reads a file of portfolio valuations (see ReadValuations; malformed last line warnings are discarded).
 func LoadValuations(fname string) (Portfolios, error) {
 	res := Portfolios{}
-	format := strings.ToLower(filepath.Ext(fname)[1:])
-	s, err := fsx.ReadFile(fname)
-	if err == nil {
-		switch format {
-		case "json":
-			err = json.Unmarshal([]byte(s), &res)
-		case "yaml":
-			err = yaml.Unmarshal([]byte(s), &res)
-		default:
-			err = fmt.Errorf("invalid format: \"%s\"", format)
-		}
-	}
+	err := ReadValuations(fname, nil, func(p Portfolio) error {
+		res = append(res, p)
+		return nil
+	})
 	return res, err
 }
 
-// SaveValuations appends the valuated portfolios to file `fname` in JSON or YAML `format.
-func (ps Portfolios) SaveValuations(fname string) (err error) {
-	valuations := Portfolios{}
-	if fsx.FileExists(fname) {
-		valuations, err = LoadValuations(fname)
+// ReadValuations calls `fn` for each valuation in file `fname`.
+// JSON and JSON Lines (".jsonl") files are streamed, YAML files are read in their entirety.
+// A malformed last line in a JSON Lines file (a valuation that was only partially written) is skipped and a warning
+// is written to `stderr` (if it is not nil); malformed lines that are not the last line are errors.
+func ReadValuations(fname string, stderr io.Writer, fn func(Portfolio) error) error {
+	format := strings.ToLower(filepath.Ext(fname)[1:])
+	switch format {
+	case "json", "jsonl":
+	case "yaml":
+		s, err := fsx.ReadFile(fname)
 		if err != nil {
-			return
+			return err
+		}
+		ps := Portfolios{}
+		if err := yaml.Unmarshal([]byte(s), &ps); err != nil {
+			return err
+		}
+		for _, p := range ps {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid format: \"%s\"", format)
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if format == "jsonl" {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		var malformed error // Set if the last line read was malformed
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			if malformed != nil {
+				return malformed
+			}
+			var p Portfolio
+			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+				malformed = fmt.Errorf("line %d: %s", line, err.Error())
+				continue
+			}
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if malformed != nil && stderr != nil {
+			fmt.Fprintf(stderr, "WARNING: valuations file: \"%s\": skipped malformed last line: %s\n", fname, malformed.Error())
+		}
+		return nil
+	}
+	// Stream the JSON array elements.
+	dec := json.NewDecoder(f)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var p Portfolio
+		if err := dec.Decode(&p); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
 		}
 	}
-	valuations = append(valuations, ps...)
+	_, err = dec.Token()
+	return err
+}
+
+// SaveValuations writes the valuations to file `fname` in JSON, JSON Lines or YAML format replacing the file's contents.
+// The file is replaced atomically and the previous version is kept in a backup file (see lockfile.WriteFile).
+func (ps Portfolios) SaveValuations(fname string) (err error) {
 	format := strings.ToLower(filepath.Ext(fname)[1:])
 	var s string
 	switch format {
 	case "json":
 		s, err = ps.ToJSON()
+	case "jsonl":
+		s, err = ps.ToJSONL()
 	case "yaml":
 		s, err = ps.ToYAML()
 	default:
@@ -187,10 +301,90 @@ func (ps Portfolios) SaveValuations(fname string) (err error) {
 	if err != nil {
 		return
 	}
-	err = fsx.WriteFile(fname, s)
+	err = lockfile.WriteFile(fname, []byte(s))
 	return
 }
 
+// AppendValuations appends the valuations to file `fname`.
+// Valuations are appended to JSON Lines files without reading the file; JSON and YAML files are read and rewritten.
+// A malformed last line in a JSON Lines file (a valuation that was only partially written) is removed before appending
+// and a warning is written to `stderr` (if it is not nil). JSON Lines appends do not update the backup file.
+func (ps Portfolios) AppendValuations(fname string, stderr io.Writer) error {
+	format := strings.ToLower(filepath.Ext(fname)[1:])
+	if format != "jsonl" {
+		valuations := Portfolios{}
+		if fsx.FileExists(fname) {
+			err := ReadValuations(fname, stderr, func(p Portfolio) error {
+				valuations = append(valuations, p)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return append(valuations, ps...).SaveValuations(fname)
+	}
+	s, err := ps.ToJSONL()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// An unterminated last line is terminated if it is a valid valuation, otherwise it is removed.
+	tail, err := lastLine(f)
+	if err != nil {
+		return err
+	}
+	if len(tail) > 0 {
+		var p Portfolio
+		if parseErr := json.Unmarshal(tail, &p); parseErr == nil {
+			s = "\n" + s
+		} else {
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if err := f.Truncate(info.Size() - int64(len(tail))); err != nil {
+				return err
+			}
+			if stderr != nil {
+				fmt.Fprintf(stderr, "WARNING: valuations file: \"%s\": removed malformed last line: %s\n", fname, parseErr.Error())
+			}
+		}
+	}
+	if _, err := f.WriteString(s); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// lastLine returns the unterminated last line of file `f` (nil if the file is empty or ends with a newline).
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	res := []byte{}
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunk := buf[:n]
+		if i := bytes.LastIndexByte(chunk, '\n'); i != -1 {
+			return append(slices.Clone(chunk[i+1:]), res...), nil
+		}
+		res = append(slices.Clone(chunk), res...)
+		end = start
+	}
+	return res, nil
+}
+
 // Aggregate returns a new portfolio that combines the valuated receiver portfolios.
 // Portfolio Notes field is assigned the list of combined portfolios.
 // Aggregated costs are valid only if all portfolios are costed.
@@ -201,29 +395,45 @@ func (ps Portfolios) Aggregate(name string) Portfolio {
 	}
 	notes := []string{}
 	isMissingCost := false
+	uncosted := set.New[string]() // Symbols of assets with one or more uncosted holdings
 	for _, p := range ps {
 		notes = append(notes, p.Name)
 		res.Value += p.Value
+		res.FXGains += p.FXGains
+		res.Realized += p.Realized
+		res.Stale = res.Stale || p.Stale
 		if p.Cost == 0 {
 			isMissingCost = true
 		}
 		res.Cost += p.Cost
 		for _, a := range p.Assets {
+			if a.Cost == 0.00 {
+				uncosted.Add(a.Symbol)
+			}
 			i := res.Assets.Find(a.Symbol)
 			if i == -1 {
-				res.Assets = append(res.Assets, Asset{Symbol: a.Symbol, Price: a.Price, Amount: a.Amount, Value: a.Value})
+				res.Assets = append(res.Assets, Asset{Symbol: a.Symbol, Price: a.Price, Spread: a.Spread, Route: a.Route, Amount: a.Amount, Value: a.Value, Cost: a.Cost, Realized: a.Realized})
 			} else {
 				res.Assets[i].Amount += a.Amount
 				res.Assets[i].Value += a.Value
+				res.Assets[i].Cost += a.Cost
+				res.Assets[i].Realized += a.Realized
 			}
 		}
 	}
 	sort.Strings(notes)
 	res.Notes = strings.Join(notes, ", ")
+	for i, a := range res.Assets {
+		if uncosted.Has(a.Symbol) {
+			res.Assets[i].Cost = 0.00 // Asset cost is "omitted" if one or more holdings are not costed
+		}
+	}
 	res.SetAllocations()
+	res.SetGains()
 	res.Assets.Sort()
 	if isMissingCost {
 		res.Cost = 0.00 // Cost is "omitted" if one or more portfolios are not costed
+		res.FXGains = 0.00
 	}
 	return res
 }
@@ -250,6 +460,21 @@ func (ps Portfolios) FindByNameAndDate(name string, date string) int {
 	return -1
 }
 
+// FindLatestBefore searches portfolios slice for the most recent portfolio named `name` dated before `date`.
+// If found it returns the portfolio index else returns -1.
+func (ps Portfolios) FindLatestBefore(name string, date string) int {
+	res := -1
+	for i := range ps {
+		if ps[i].Name != name || ps[i].Date >= date {
+			continue
+		}
+		if res == -1 || ps[i].Date+ps[i].Time > ps[res].Date+ps[res].Time {
+			res = i
+		}
+	}
+	return res
+}
+
 // Find searches portfolios slice for a portfolio whose name matches `name`.
 // If found return the portfolio index else return -1.
 func (ps Portfolios) FindByName(name string) int {
@@ -261,6 +486,21 @@ func (ps Portfolios) FindByName(name string) int {
 	return -1
 }
 
+// UnpricedSymbols returns the sorted list of distinct symbols of assets that have not been priced.
+func (ps Portfolios) UnpricedSymbols() []string {
+	symbols := set.New[string]()
+	for _, p := range ps {
+		for _, a := range p.Assets {
+			if a.Price == 0 {
+				symbols.Add(a.Symbol)
+			}
+		}
+	}
+	res := symbols.Values()
+	sort.Strings(res)
+	return res
+}
+
 // SetAssetPrice sets the unit price of assets named `name` to `price`.
 func (ps Portfolios) SetAssetPrice(name string, price float64) (err error) {
 	found := false
@@ -344,7 +584,8 @@ func (p Portfolio) pcgains() float64 {
 	}
 }
 
-func (ps *Portfolios) ToText(currency string, xrate float64) string {
+// ToText returns the portfolios formatted as text, values are converted to `currency` at the `xrate` exchange rate dated `xrateDate`.
+func (ps *Portfolios) ToText(currency string, xrate float64, xrateDate string) string {
 	res := ""
 	for _, p := range *ps {
 		if p.Notes == "" {
@@ -354,16 +595,42 @@ func (ps *Portfolios) ToText(currency string, xrate float64) string {
 			res += fmt.Sprintf("NAME:  %s\nNOTES: %s\nDATE:  %s\nTIME:  %s\nVALUE: %.2f %s",
 				p.Name, p.Notes, p.Date, p.Time, p.Value*xrate, currency)
 		}
+		if p.Stale {
+			res += "\nSTALE: valuated with offline cached prices"
+		}
 		if p.Cost > 0.00 {
-			res += fmt.Sprintf("\nCOST:  %.2f %s\nGAINS: %.2f %s (%.2f%%)", p.Cost*xrate, currency, p.gains()*xrate, currency, p.pcgains())
+			res += fmt.Sprintf("\nCOST:  %.2f %s", p.Cost*xrate, currency)
+			if p.CostDate != "" {
+				res += fmt.Sprintf(" (%.2f %s on %s)", p.CostAmount, p.CostCurrency, p.CostDate)
+			}
+			res += fmt.Sprintf("\nGAINS: %.2f %s (%.2f%%)", p.gains()*xrate, currency, p.pcgains())
+			if p.CostDate != "" || p.FXGains != 0.00 {
+				res += fmt.Sprintf("\nFX:    %.2f %s", p.FXGains*xrate, currency)
+			}
+		}
+		if p.Realized != 0.00 {
+			res += fmt.Sprintf("\nREALIZED: %.2f %s", p.Realized*xrate, currency)
 		}
 		if currency != "USD" {
-			res += fmt.Sprintf("\nXRATE: 1 USD = %.2f %s", xrate, currency)
+			res += fmt.Sprintf("\nXRATE: 1 USD = %.2f %s (%s)", xrate, currency, xrateDate)
 		}
-		res += "\n            AMOUNT            VALUE    PERCENT       UNIT PRICE\n"
+		// Cost and gains columns are only printed if one or more assets have a cost basis or realized gains.
+		hasCost, hasRealized := false, false
+		for _, a := range p.Assets {
+			hasCost = hasCost || a.Cost != 0.00
+			hasRealized = hasRealized || a.Realized != 0.00
+		}
+		res += "\n            AMOUNT            VALUE    PERCENT       UNIT PRICE"
+		if hasCost {
+			res += "             COST            GAINS     GAINS%"
+		}
+		if hasRealized {
+			res += "         REALIZED"
+		}
+		res += "\n"
 		for _, a := range p.Assets {
 			value := a.Value * xrate
-			res += fmt.Sprintf("%-5s %12.4f %12.2f %s    %6.2f%% %12.2f %s\n",
+			line := fmt.Sprintf("%-5s %12.4f %12.2f %s    %6.2f%% %12.2f %s",
 				a.Symbol,
 				a.Amount,
 				value,
@@ -371,16 +638,28 @@ func (ps *Portfolios) ToText(currency string, xrate float64) string {
 				a.Allocation,
 				helpers.If(a.Amount > 0.0, value/a.Amount, 0),
 				currency)
+			if hasCost {
+				if a.Cost != 0.00 {
+					line += fmt.Sprintf(" %12.2f %s %12.2f %s %9.2f%%", a.Cost*xrate, currency, a.Gains*xrate, currency, a.GainsPct)
+				} else {
+					line += fmt.Sprintf(" %16s %16s %10s", "", "", "")
+				}
+			}
+			if hasRealized {
+				line += fmt.Sprintf(" %12.2f %s", a.Realized*xrate, currency)
+			}
+			line += helpers.If(a.Route != "", "    ("+a.Route+")", "")
+			res += strings.TrimRight(line, " ") + "\n"
 		}
 		res += "\n"
 	}
 	return res
 }
 
-func (ps Portfolios) ToString(format string, currency string, xrate float64) (res string, err error) {
+func (ps Portfolios) ToString(format string, currency string, xrate float64, xrateDate string) (res string, err error) {
 	switch format {
 	case "":
-		res = ps.ToText(currency, xrate)
+		res = ps.ToText(currency, xrate, xrateDate)
 	case "json":
 		res, err = ps.ToJSON()
 		if err != nil {
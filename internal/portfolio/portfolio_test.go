@@ -1,14 +1,20 @@
 package portfolio
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"path"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/srackham/cryptor/internal/binance"
 	"github.com/srackham/cryptor/internal/mock"
 	"github.com/srackham/go-utils/assert"
+	"github.com/srackham/go-utils/fsx"
+	"github.com/srackham/go-utils/helpers"
 )
 
 func TestIsValidPortfolioName(t *testing.T) {
@@ -76,6 +82,92 @@ func TestSaveValuationsFile(t *testing.T) {
 	test("yaml")
 }
 
+func TestAppendValuations(t *testing.T) {
+	ctx := mock.NewContext()
+	tmpdir := mock.MkdirTemp(t)
+	valuations, err := LoadValuations(path.Join(ctx.DataDir, "valuations.json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	test := func(format string) {
+		fname := filepath.Join(tmpdir, "valuations."+format)
+		err := valuations[:10].AppendValuations(fname, nil)
+		assert.PassIf(t, err == nil, "%v", err)
+		err = valuations[10:].AppendValuations(fname, nil)
+		assert.PassIf(t, err == nil, "%v", err)
+		loaded, err := LoadValuations(fname)
+		assert.PassIf(t, err == nil, "%v", err)
+		assert.PassIf(t, reflect.DeepEqual(valuations, loaded),
+			"valuations file: \"%v\": expected:\n%v\n\ngot:\n%v", fname, valuations, loaded)
+	}
+	test("json")
+	test("jsonl")
+	test("yaml")
+
+	// JSON Lines files have one valuation per line.
+	fname := filepath.Join(tmpdir, "valuations.jsonl")
+	s, err := fsx.ReadFile(fname)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 15, strings.Count(s, "\n"))
+
+	// A partially written last line is skipped with a warning.
+	torn := `{"name":"personal","date":"2000-12-01"}` + "\n" + `{"name":"per`
+	err = fsx.WriteFile(fname, torn)
+	assert.PassIf(t, err == nil, "%v", err)
+	stderr := &bytes.Buffer{}
+	count := 0
+	err = ReadValuations(fname, stderr, func(p Portfolio) error {
+		count++
+		return nil
+	})
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1, count)
+	assert.EqualStrings(t, `WARNING: valuations file: "`+fname+`": skipped malformed last line: line 2: unexpected end of JSON input`+"\n", stderr.String())
+
+	// A partially written last line is removed before appending.
+	stderr.Reset()
+	err = valuations[:1].AppendValuations(fname, stderr)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Contains(t, stderr.String(), "removed malformed last line")
+	loaded, err := LoadValuations(fname)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2, len(loaded))
+	assert.PassIf(t, reflect.DeepEqual(valuations[0], loaded[1]), "unexpected valuation: %v", loaded[1])
+
+	// Partially written last lines longer than the read buffer are removed.
+	err = fsx.WriteFile(fname, torn+strings.Repeat("x", 10000))
+	assert.PassIf(t, err == nil, "%v", err)
+	err = valuations[:1].AppendValuations(fname, nil)
+	assert.PassIf(t, err == nil, "%v", err)
+	loaded, err = LoadValuations(fname)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2, len(loaded))
+
+	// An unterminated valid last line is terminated before appending.
+	err = fsx.WriteFile(fname, `{"name":"personal","date":"2000-12-01"}`)
+	assert.PassIf(t, err == nil, "%v", err)
+	err = valuations[:1].AppendValuations(fname, nil)
+	assert.PassIf(t, err == nil, "%v", err)
+	loaded, err = LoadValuations(fname)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2, len(loaded))
+
+	// Malformed lines that are not the last line are errors.
+	err = fsx.WriteFile(fname, torn+"\n"+`{"name":"personal","date":"2000-12-02"}`+"\n")
+	assert.PassIf(t, err == nil, "%v", err)
+	_, err = LoadValuations(fname)
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, "line 2: unexpected end of JSON input", err.Error())
+
+	// Streamed reads can be stopped early.
+	count = 0
+	stop := fmt.Errorf("stop")
+	err = ReadValuations(path.Join(ctx.DataDir, "valuations.json"), nil, func(p Portfolio) error {
+		count++
+		return helpers.If(count == 3, stop, nil)
+	})
+	assert.PassIf(t, err == stop, "unexpected error: %v", err)
+	assert.Equal(t, 3, count)
+}
+
 func TestParseCurrency(t *testing.T) {
 	tests := []struct {
 		input   string
@@ -208,7 +300,7 @@ func TestPortfolio_SetUSDValues(t *testing.T) {
 			{Symbol: "ETH", Amount: 10},
 		},
 	}
-	err := p.SetUSDValues(&mockReader)
+	err := p.SetUSDValues(context.Background(), &mockReader)
 	if err != nil {
 		t.Fatalf("SetUSDValues() error = %v", err)
 	}
@@ -247,6 +339,30 @@ func TestPortfolio_SetAllocations(t *testing.T) {
 	}
 }
 
+func TestPortfolio_SetGains(t *testing.T) {
+	p := &Portfolio{
+		Value: 100000,
+		Assets: Assets{
+			{Symbol: "BTC", Value: 60000, Cost: 40000},
+			{Symbol: "ETH", Value: 40000},
+		},
+	}
+	p.SetGains()
+	expected := Assets{
+		{Symbol: "BTC", Value: 60000, Cost: 40000, Gains: 20000, GainsPct: 50},
+		{Symbol: "ETH", Value: 40000},
+	}
+	if !reflect.DeepEqual(p.Assets, expected) {
+		t.Errorf("SetGains() assets = %+v, want %+v", p.Assets, expected)
+	}
+	// Aggregated asset costs are omitted if one or more holdings are not costed.
+	ps := Portfolios{*p, {Name: "Portfolio 2", Value: 1000, Assets: Assets{{Symbol: "BTC", Value: 1000}}}}
+	aggregated := ps.Aggregate("aggregate")
+	if i := aggregated.Assets.Find("BTC"); aggregated.Assets[i].Cost != 0 || aggregated.Assets[i].Gains != 0 {
+		t.Errorf("Aggregate() BTC asset = %+v, want uncosted asset", aggregated.Assets[i])
+	}
+}
+
 func TestPortfolio_DeepCopy(t *testing.T) {
 	original := Portfolio{
 		Name: "Test Portfolio",
@@ -270,20 +386,22 @@ func TestPortfolio_DeepCopy(t *testing.T) {
 func TestPortfolios_Aggregate(t *testing.T) {
 	portfolios := Portfolios{
 		{
-			Name:  "Portfolio 1",
-			Value: 100000,
-			Cost:  90000,
+			Name:     "Portfolio 1",
+			Value:    100000,
+			Cost:     90000,
+			Realized: 1000,
 			Assets: Assets{
-				{Symbol: "BTC", Amount: 1, Value: 60000},
+				{Symbol: "BTC", Amount: 1, Value: 60000, Cost: 50000, Realized: 1000},
 				{Symbol: "ETH", Amount: 20, Value: 40000},
 			},
 		},
 		{
-			Name:  "Portfolio 2",
-			Value: 50000,
-			Cost:  45000,
+			Name:     "Portfolio 2",
+			Value:    50000,
+			Cost:     45000,
+			Realized: 500,
 			Assets: Assets{
-				{Symbol: "BTC", Amount: 0.5, Value: 30000},
+				{Symbol: "BTC", Amount: 0.5, Value: 30000, Cost: 20000, Realized: 500},
 				{Symbol: "XRP", Amount: 1000, Value: 20000},
 			},
 		},
@@ -297,8 +415,12 @@ func TestPortfolios_Aggregate(t *testing.T) {
 	if aggregated.Cost != expectedCost {
 		t.Errorf("Aggregate() cost = %v, want %v", aggregated.Cost, expectedCost)
 	}
+	expectedRealized := 1500.0
+	if aggregated.Realized != expectedRealized {
+		t.Errorf("Aggregate() realized = %v, want %v", aggregated.Realized, expectedRealized)
+	}
 	expectedAssets := Assets{
-		{Symbol: "BTC", Amount: 1.5, Value: 90000},
+		{Symbol: "BTC", Amount: 1.5, Value: 90000, Cost: 70000, Realized: 1500},
 		{Symbol: "ETH", Amount: 20, Value: 40000},
 		{Symbol: "XRP", Amount: 1000, Value: 20000},
 	}
@@ -308,7 +430,8 @@ func TestPortfolios_Aggregate(t *testing.T) {
 	for _, expectedAsset := range expectedAssets {
 		found := false
 		for _, asset := range aggregated.Assets {
-			if asset.Symbol == expectedAsset.Symbol && asset.Amount == expectedAsset.Amount && asset.Value == expectedAsset.Value {
+			if asset.Symbol == expectedAsset.Symbol && asset.Amount == expectedAsset.Amount && asset.Value == expectedAsset.Value &&
+				asset.Cost == expectedAsset.Cost && asset.Realized == expectedAsset.Realized {
 				found = true
 				break
 			}
@@ -344,6 +467,33 @@ func TestPortfolios_FindByNameAndDate(t *testing.T) {
 	}
 }
 
+func TestPortfolios_FindLatestBefore(t *testing.T) {
+	portfolios := Portfolios{
+		{Name: "Portfolio 1", Date: "2025-03-15", Time: "12:00:00"},
+		{Name: "Portfolio 1", Date: "2025-03-15", Time: "18:00:00"},
+		{Name: "Portfolio 2", Date: "2025-03-16"},
+		{Name: "Portfolio 1", Date: "2025-03-17"},
+	}
+	tests := []struct {
+		name     string
+		pName    string
+		date     string
+		expected int
+	}{
+		{"Find latest earlier portfolio", "Portfolio 1", "2025-03-17", 1},
+		{"Find latest portfolio", "Portfolio 1", "2025-03-20", 3},
+		{"No earlier portfolio", "Portfolio 1", "2025-03-15", -1},
+		{"Find non-existing portfolio", "Portfolio 3", "2025-03-20", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portfolios.FindLatestBefore(tt.pName, tt.date); got != tt.expected {
+				t.Errorf("Portfolios.FindLatestBefore() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestPortfolios_FindByName(t *testing.T) {
 	portfolios := Portfolios{
 		{Name: "Portfolio 1"},
@@ -367,6 +517,17 @@ func TestPortfolios_FindByName(t *testing.T) {
 	}
 }
 
+func TestPortfolios_UnpricedSymbols(t *testing.T) {
+	portfolios := Portfolios{
+		{Name: "Portfolio 1", Assets: Assets{{Symbol: "ETH"}, {Symbol: "BTC"}, {Symbol: "GOLD", Price: 3000}}},
+		{Name: "Portfolio 2", Assets: Assets{{Symbol: "BTC"}, {Symbol: "XRP"}}},
+	}
+	got := portfolios.UnpricedSymbols()
+	if !reflect.DeepEqual(got, []string{"BTC", "ETH", "XRP"}) {
+		t.Errorf("Portfolios.UnpricedSymbols() = %v", got)
+	}
+}
+
 func TestPortfolios_SetAssetPrice(t *testing.T) {
 	portfolios := Portfolios{
 		{
@@ -0,0 +1,238 @@
+package portfolio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/srackham/go-utils/helpers"
+	"github.com/srackham/go-utils/set"
+	"gopkg.in/yaml.v3"
+)
+
+// Target is a portfolio target allocation for an asset or a group of assets.
+type Target struct {
+	Name    string   // Asset symbol or group name
+	Symbols []string // The target's asset symbols (a group target has one or more symbols)
+	Percent float64  // Target percentage of the portfolio value
+}
+
+type Targets []Target
+
+// Trade is a rebalancing asset purchase or sale.
+type Trade struct {
+	Symbol  string  `yaml:"symbol"  json:"symbol"`  // Crypto currency symbol
+	Target  string  `yaml:"target"  json:"target"`  // Target name (blank if the asset is not targeted)
+	Amount  float64 `yaml:"amount"  json:"amount"`  // Number of asset units to buy (positive) or sell (negative)
+	Value   float64 `yaml:"value"   json:"value"`   // Trade value in USD to buy (positive) or sell (negative)
+	Current float64 `yaml:"current" json:"current"` // Current target allocation percentage
+	Percent float64 `yaml:"percent" json:"percent"` // Target allocation percentage
+}
+
+// Rebalance is a portfolio rebalancing plan.
+type Rebalance struct {
+	Name   string  `yaml:"name"   json:"name"`   // Porfolio name
+	Date   string  `yaml:"date"   json:"date"`   // The valuation date formatted "YYYY-MM-DD"
+	Value  float64 `yaml:"value"  json:"value"`  // Current portfolio value in USD
+	Cash   float64 `yaml:"cash"   json:"cash"`   // Net cash in USD required by the trades (negative if the trades release cash)
+	Trades []Trade `yaml:"trades" json:"trades"` // Trades sorted by descending value
+}
+
+type Rebalances []Rebalance
+
+// NewTargets returns portfolio targets; `targets` maps asset symbols and group names to target percentages
+// and `groups` maps group names to lists of asset symbols.
+func NewTargets(targets map[string]float64, groups map[string][]string) (Targets, error) {
+	var res Targets
+	total := 0.0
+	symbols := set.New[string]()
+	for name, percent := range targets {
+		if percent < 0 {
+			return nil, fmt.Errorf("invalid target percentage: %s: %.2f", name, percent)
+		}
+		t := Target{Name: name, Percent: percent}
+		if members, ok := groups[name]; ok {
+			if len(members) == 0 {
+				return nil, fmt.Errorf("empty target group: \"%s\"", name)
+			}
+			for _, symbol := range members {
+				t.Symbols = append(t.Symbols, strings.ToUpper(symbol))
+			}
+		} else {
+			t.Name = strings.ToUpper(name)
+			t.Symbols = []string{t.Name}
+		}
+		for _, symbol := range t.Symbols {
+			if symbols.Has(symbol) {
+				return nil, fmt.Errorf("asset has more than one target: \"%s\"", symbol)
+			}
+			symbols.Add(symbol)
+		}
+		total += percent
+		res = append(res, t)
+	}
+	for name := range groups {
+		if _, ok := targets[name]; !ok {
+			return nil, fmt.Errorf("group has no target: \"%s\"", name)
+		}
+	}
+	if len(res) > 0 && math.Abs(total-100) > 0.01 {
+		return nil, fmt.Errorf("targets do not total 100%%: %.2f%%", total)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// Find returns the index of the target that includes asset `symbol` or -1 if the asset is not targeted.
+func (targets Targets) Find(symbol string) int {
+	for i, t := range targets {
+		for _, s := range t.Symbols {
+			if s == symbol {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Allocations returns the current allocation percentage of each target.
+func (p Portfolio) Allocations() map[string]float64 {
+	res := make(map[string]float64)
+	for _, t := range p.Targets {
+		res[t.Name] = 0
+		for _, symbol := range t.Symbols {
+			if i := p.Assets.Find(symbol); i != -1 {
+				res[t.Name] += p.Assets[i].Allocation
+			}
+		}
+	}
+	return res
+}
+
+// Rebalance returns the trades that bring the valuated portfolio's asset allocations into line with its targets.
+// The portfolio assets must include all targeted assets (unheld assets have zero amounts) and must be priced.
+// A target's trade value is split between its assets in proportion to their current values (or equally if none are held).
+// Untargeted assets are sold. If `noSell` is true the portfolio is rebalanced with cash purchases only: the plan buys the
+// minimum amount of targeted assets that rebalances the targeted assets; untargeted assets and assets with zero targets
+// are left unchanged.
+// Trades valued at less than `minTrade` USD are omitted.
+func (p Portfolio) Rebalance(minTrade float64, noSell bool) (Rebalance, error) {
+	res := Rebalance{Name: p.Name, Date: p.Date, Value: p.Value, Trades: []Trade{}}
+	if len(p.Targets) == 0 {
+		return res, fmt.Errorf("portfolio has no targets: \"%s\"", p.Name)
+	}
+	// Calculate the current target values.
+	values := make([]float64, len(p.Targets))
+	targeted := 0.0
+	for i, t := range p.Targets {
+		for _, symbol := range t.Symbols {
+			j := p.Assets.Find(symbol)
+			if j == -1 {
+				return res, fmt.Errorf("missing target asset: \"%s\"", symbol)
+			}
+			if p.Assets[j].Price <= 0 {
+				return res, fmt.Errorf("unpriced target asset: \"%s\"", symbol)
+			}
+			values[i] += p.Assets[j].Value
+		}
+		targeted += values[i]
+	}
+	// Calculate the portfolio value after rebalancing.
+	total := p.Value
+	if noSell {
+		total = 0
+		for i, t := range p.Targets {
+			if t.Percent > 0 {
+				total += values[i]
+			}
+		}
+		for i, t := range p.Targets {
+			if t.Percent > 0 {
+				total = math.Max(total, values[i]/(t.Percent/100))
+			}
+		}
+	}
+	current := func(i int) float64 {
+		if p.Value == 0 {
+			return 0
+		}
+		return values[i] / p.Value * 100
+	}
+	for i, t := range p.Targets {
+		delta := t.Percent/100*total - values[i]
+		if noSell {
+			delta = math.Max(delta, 0)
+		}
+		for _, symbol := range t.Symbols {
+			a := p.Assets[p.Assets.Find(symbol)]
+			share := 1 / float64(len(t.Symbols))
+			if values[i] > 0 {
+				share = a.Value / values[i]
+			}
+			value := delta * share
+			res.Trades = append(res.Trades, Trade{Symbol: symbol, Target: t.Name, Amount: value / a.Price, Value: value, Current: current(i), Percent: t.Percent})
+		}
+	}
+	if !noSell {
+		for _, a := range p.Assets {
+			if p.Targets.Find(a.Symbol) == -1 && a.Value > 0 {
+				res.Trades = append(res.Trades, Trade{Symbol: a.Symbol, Amount: -a.Amount, Value: -a.Value, Current: a.Allocation})
+			}
+		}
+	}
+	trades := []Trade{}
+	for _, t := range res.Trades {
+		if math.Abs(t.Value) >= math.Max(minTrade, 0.005) {
+			trades = append(trades, t)
+			res.Cash += t.Value
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].Value > trades[j].Value
+	})
+	res.Trades = trades
+	return res, nil
+}
+
+// ToText returns the rebalancing plans formatted as text with fiat values denominated in `currency`.
+func (rs Rebalances) ToText(currency string, xrate float64) string {
+	res := ""
+	for _, r := range rs {
+		res += fmt.Sprintf("NAME:  %s\nDATE:  %s\nVALUE: %.2f %s\nCASH:  %.2f %s\n", r.Name, r.Date, r.Value*xrate, currency, r.Cash*xrate, currency)
+		if len(r.Trades) == 0 {
+			res += "no trades required\n\n"
+			continue
+		}
+		res += "TRADE SYMBOL         AMOUNT            VALUE    CURRENT     TARGET\n"
+		for _, t := range r.Trades {
+			line := fmt.Sprintf("%-5s %-6s %12.4f %12.2f %s    %6.2f%%    %6.2f%%",
+				helpers.If(t.Value > 0, "buy", "sell"),
+				t.Symbol,
+				math.Abs(t.Amount),
+				math.Abs(t.Value)*xrate,
+				currency,
+				t.Current,
+				t.Percent)
+			if t.Target != t.Symbol && t.Target != "" {
+				line += "    (" + t.Target + ")"
+			}
+			res += line + "\n"
+		}
+		res += "\n"
+	}
+	return res
+}
+
+func (rs Rebalances) ToJSON() (string, error) {
+	data, err := json.MarshalIndent(rs, "", "  ")
+	return string(data) + "\n", err
+}
+
+func (rs Rebalances) ToYAML() (string, error) {
+	data, err := yaml.Marshal(rs)
+	return string(data), err
+}
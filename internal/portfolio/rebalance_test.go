@@ -0,0 +1,140 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+
+	"github.com/srackham/go-utils/assert"
+)
+
+// valuated returns a portfolio with asset values and allocations calculated from the asset amounts and prices.
+func valuated(assets Assets, targets Targets) Portfolio {
+	p := Portfolio{Name: "portfolio1", Date: "2000-12-01", Assets: assets, Targets: targets}
+	for i := range p.Assets {
+		p.Assets[i].Value = p.Assets[i].Amount * p.Assets[i].Price
+		p.Value += p.Assets[i].Value
+	}
+	p.SetAllocations()
+	return p
+}
+
+func TestNewTargets(t *testing.T) {
+	targets, err := NewTargets(map[string]float64{"eth": 30, "BTC": 50, "stable": 20}, map[string][]string{"stable": {"usdc", "USDT"}})
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(targets))
+	assert.Equal(t, "BTC", targets[0].Name)
+	assert.Equal(t, "ETH", targets[1].Name)
+	assert.Equal(t, "stable", targets[2].Name)
+	assert.Equal(t, 2, targets.Find("USDT"))
+	assert.Equal(t, -1, targets.Find("SOL"))
+
+	targets, err = NewTargets(nil, nil)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.PassIf(t, targets == nil, "unexpected targets: %v", targets)
+
+	tests := []struct {
+		targets map[string]float64
+		groups  map[string][]string
+		errMsg  string
+	}{
+		{map[string]float64{"BTC": 110, "ETH": -10}, nil, `invalid target percentage: ETH: -10.00`},
+		{map[string]float64{"BTC": 50, "ETH": 40}, nil, `targets do not total 100%: 90.00%`},
+		{map[string]float64{"BTC": 50, "stable": 50}, map[string][]string{"stable": {}}, `empty target group: "stable"`},
+		{map[string]float64{"BTC": 100}, map[string][]string{"stable": {"USDC"}}, `group has no target: "stable"`},
+		{map[string]float64{"BTC": 50, "coins": 50}, map[string][]string{"coins": {"btc"}}, `asset has more than one target: "BTC"`},
+	}
+	for _, tt := range tests {
+		_, err := NewTargets(tt.targets, tt.groups)
+		assert.PassIf(t, err != nil, "%v: error expected", tt.targets)
+		assert.EqualStrings(t, tt.errMsg, err.Error())
+	}
+}
+
+func TestPortfolio_Rebalance(t *testing.T) {
+	targets, err := NewTargets(map[string]float64{"BTC": 50, "ETH": 30, "stable": 20}, map[string][]string{"stable": {"USDC", "USDT"}})
+	assert.PassIf(t, err == nil, "%v", err)
+	assets := func() Assets {
+		return Assets{
+			{Symbol: "BTC", Amount: 0.6, Price: 100000},
+			{Symbol: "ETH", Amount: 10, Price: 1000},
+			{Symbol: "USDC", Amount: 20000, Price: 1},
+			{Symbol: "USDT", Amount: 0, Price: 1},
+			{Symbol: "DOGE", Amount: 100000, Price: 0.1},
+		}
+	}
+	equal := func(wanted, got Trade) {
+		assert.Equal(t, wanted.Symbol, got.Symbol)
+		assert.Equal(t, wanted.Target, got.Target)
+		assert.PassIf(t, math.Abs(wanted.Amount-got.Amount) < 1e-9, "%v: wanted amount %v, got %v", wanted.Symbol, wanted.Amount, got.Amount)
+		assert.PassIf(t, math.Abs(wanted.Value-got.Value) < 1e-6, "%v: wanted value %v, got %v", wanted.Symbol, wanted.Value, got.Value)
+	}
+
+	// Untargeted assets are sold.
+	p := valuated(assets(), targets)
+	plan, err := p.Rebalance(0, false)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(plan.Trades))
+	equal(Trade{Symbol: "ETH", Target: "ETH", Amount: 20, Value: 20000}, plan.Trades[0])
+	equal(Trade{Symbol: "BTC", Target: "BTC", Amount: -0.1, Value: -10000}, plan.Trades[1])
+	equal(Trade{Symbol: "DOGE", Amount: -100000, Value: -10000}, plan.Trades[2])
+	assert.PassIf(t, math.Abs(plan.Cash) < 1e-6, "unexpected cash: %v", plan.Cash)
+	assert.PassIf(t, math.Abs(60-plan.Trades[1].Current) < 1e-9, "unexpected current allocation: %v", plan.Trades[1].Current)
+	assert.Equal(t, 50.0, plan.Trades[1].Percent)
+
+	// Cash purchases only.
+	plan, err = p.Rebalance(0, true)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2, len(plan.Trades))
+	equal(Trade{Symbol: "ETH", Target: "ETH", Amount: 26, Value: 26000}, plan.Trades[0])
+	equal(Trade{Symbol: "USDC", Target: "stable", Amount: 4000, Value: 4000}, plan.Trades[1])
+	assert.PassIf(t, math.Abs(30000-plan.Cash) < 1e-6, "unexpected cash: %v", plan.Cash)
+
+	// Minimum trade size.
+	plan, err = p.Rebalance(5000, true)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1, len(plan.Trades))
+	assert.PassIf(t, math.Abs(26000-plan.Cash) < 1e-6, "unexpected cash: %v", plan.Cash)
+
+	// Group purchases are split equally when no group assets are held.
+	p = valuated(Assets{
+		{Symbol: "BTC", Amount: 1, Price: 100000},
+		{Symbol: "USDC", Price: 1},
+		{Symbol: "USDT", Price: 1},
+	}, Targets{{Name: "BTC", Symbols: []string{"BTC"}, Percent: 50}, {Name: "stable", Symbols: []string{"USDC", "USDT"}, Percent: 50}})
+	plan, err = p.Rebalance(0, false)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(plan.Trades))
+	equal(Trade{Symbol: "USDC", Target: "stable", Amount: 25000, Value: 25000}, plan.Trades[0])
+	equal(Trade{Symbol: "USDT", Target: "stable", Amount: 25000, Value: 25000}, plan.Trades[1])
+	equal(Trade{Symbol: "BTC", Target: "BTC", Amount: -0.5, Value: -50000}, plan.Trades[2])
+
+	// Targeted assets must be priced.
+	p.Assets[1].Price = 0
+	_, err = p.Rebalance(0, false)
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `unpriced target asset: "USDC"`, err.Error())
+
+	// Assets with zero targets are sold but are left unchanged by cash purchases.
+	p = valuated(Assets{
+		{Symbol: "BTC", Amount: 1, Price: 100000},
+		{Symbol: "USDC", Amount: 50000, Price: 1},
+		{Symbol: "ETH", Amount: 1, Price: 50},
+	}, Targets{{Name: "BTC", Symbols: []string{"BTC"}, Percent: 50}, {Name: "ETH", Symbols: []string{"ETH"}, Percent: 0}, {Name: "USDC", Symbols: []string{"USDC"}, Percent: 50}})
+	plan, err = p.Rebalance(0, false)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 3, len(plan.Trades))
+	equal(Trade{Symbol: "USDC", Target: "USDC", Amount: 25025, Value: 25025}, plan.Trades[0])
+	equal(Trade{Symbol: "ETH", Target: "ETH", Amount: -1, Value: -50}, plan.Trades[1])
+	equal(Trade{Symbol: "BTC", Target: "BTC", Amount: -0.24975, Value: -24975}, plan.Trades[2])
+	plan, err = p.Rebalance(0, true)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1, len(plan.Trades))
+	equal(Trade{Symbol: "USDC", Target: "USDC", Amount: 50000, Value: 50000}, plan.Trades[0])
+	p = valuated(Assets{
+		{Symbol: "BTC", Amount: 1, Price: 100000},
+		{Symbol: "ETH", Amount: 1, Price: 50},
+	}, Targets{{Name: "BTC", Symbols: []string{"BTC"}, Percent: 100}, {Name: "ETH", Symbols: []string{"ETH"}, Percent: 0}})
+	plan, err = p.Rebalance(0, true)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 0, len(plan.Trades))
+}
@@ -0,0 +1,110 @@
+package price
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/cryptor/internal/lockfile"
+	"github.com/srackham/go-utils/cache"
+	"github.com/srackham/go-utils/set"
+)
+
+// Cache data types.
+type CachedPrice struct {
+	Price float64   `json:"price"`           // Asset value in USD
+	Time  time.Time `json:"time"`            // When the price was fetched
+	Route string    `json:"route,omitempty"` // Trading pairs used to derive the price (derived prices only)
+}
+type Prices map[string]CachedPrice // Key = asset symbol.
+
+// Cache is an asset price cache that is safe for concurrent use.
+// Prices are persisted to the cache file by the SaveCache method and restored by the LoadCache method.
+type Cache struct {
+	*Context
+	*cache.Cache[Prices]
+	TTL     time.Duration // Maximum age of prices loaded from the cache file
+	Offline bool          // If true prices loaded from the cache file never expire
+	mu      sync.Mutex
+	fresh   set.Set[string] // Symbols of prices fetched since the cache was created
+}
+
+// NewCache returns a price cache that is persisted to `cacheFile`.
+func NewCache(ctx *Context, cacheFile string) *Cache {
+	data := make(Prices)
+	result := Cache{
+		Context: ctx,
+		Cache:   cache.New(&data),
+		fresh:   set.New[string](),
+	}
+	result.CacheFile = cacheFile
+	return &result
+}
+
+// LoadCache restores prices from the cache file.
+func (c *Cache) LoadCache() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Load()
+}
+
+// SaveCache writes prices to the cache file, the cache directory is created if it does not exist.
+// The cache file is locked and prices in the cache file that are newer than the cached prices are merged into the
+// cache, so prices saved by concurrent cryptor processes are not lost. The file is written atomically.
+func (c *Cache) SaveCache() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return lockfile.Update(c.CacheFile, func(data []byte) ([]byte, error) {
+		if data != nil {
+			saved := make(Prices)
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return nil, err
+			}
+			for symbol, entry := range saved {
+				if cached, ok := (*c.CacheData)[symbol]; !ok || entry.Time.After(cached.Time) {
+					(*c.CacheData)[symbol] = entry
+				}
+			}
+		}
+		return json.MarshalIndent(*c.CacheData, "", "  ")
+	})
+}
+
+// Get returns the cached price of asset `symbol`.
+// Prices loaded from the cache file are only returned if they are no older than the cache TTL or if the cache is offline.
+func (c *Cache) Get(symbol string) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	symbol = strings.ToUpper(symbol)
+	entry, ok := (*c.CacheData)[symbol]
+	if !ok {
+		return 0, false
+	}
+	if c.fresh.Has(symbol) || c.Offline || (c.TTL > 0 && c.Now().Sub(entry.Time) <= c.TTL) {
+		return entry.Price, true
+	}
+	return 0, false
+}
+
+// Put caches the `price` of asset `symbol`.
+func (c *Cache) Put(symbol string, price float64) {
+	c.PutRoute(symbol, price, "")
+}
+
+// PutRoute caches the `price` of asset `symbol` along with the `route` used to derive it.
+func (c *Cache) PutRoute(symbol string, price float64, route string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	symbol = strings.ToUpper(symbol)
+	(*c.CacheData)[symbol] = CachedPrice{Price: price, Time: c.Now(), Route: route}
+	c.fresh.Add(symbol)
+}
+
+// Route returns the route used to derive the cached price of asset `symbol`.
+func (c *Cache) Route(symbol string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return (*c.CacheData)[strings.ToUpper(symbol)].Route
+}
@@ -0,0 +1,45 @@
+package price
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestCache(t *testing.T) {
+	ctx := mock.NewContext()
+	tmpdir := mock.MkdirTemp(t)
+	cacheFile := filepath.Join(tmpdir, "prices.json")
+	c := NewCache(&ctx, cacheFile)
+	c.Put("btc", 100_000)
+	price, ok := c.Get("BTC")
+	assert.PassIf(t, ok, "missing cached price")
+	assert.Equal(t, 100_000.0, price)
+	err := c.SaveCache()
+	assert.PassIf(t, err == nil, "%v", err)
+
+	// Prices loaded from the cache file expire after the TTL.
+	now := ctx.Now()
+	ctx.Now = func() time.Time { return now.Add(10 * time.Minute) }
+	c = NewCache(&ctx, cacheFile)
+	err = c.LoadCache()
+	assert.PassIf(t, err == nil, "%v", err)
+	_, ok = c.Get("BTC")
+	assert.PassIf(t, !ok, "cached price should have expired")
+	c.TTL = 15 * time.Minute
+	price, ok = c.Get("BTC")
+	assert.PassIf(t, ok, "cached price should not have expired")
+	assert.Equal(t, 100_000.0, price)
+
+	// Offline prices never expire.
+	c.TTL = 0
+	c.Offline = true
+	price, ok = c.Get("BTC")
+	assert.PassIf(t, ok, "offline cached price should not expire")
+	assert.Equal(t, 100_000.0, price)
+	_, ok = c.Get("ETH")
+	assert.PassIf(t, !ok, "unexpected cached price")
+}
@@ -0,0 +1,110 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Chain is a price source that queries an ordered list of price sources, if a source fails to price an asset the next source is tried.
+// Assets can be pinned to a specific price source.
+type Chain struct {
+	sources []PriceSource          // Price sources in order of precedence
+	pinned  map[string]PriceSource // Maps asset symbols to pinned price sources
+}
+
+// NewChain returns a price source chain; `pinned` maps asset symbols to price sources.
+func NewChain(sources []PriceSource, pinned map[string]PriceSource) *Chain {
+	result := Chain{
+		sources: sources,
+		pinned:  make(map[string]PriceSource),
+	}
+	for k, v := range pinned {
+		result.pinned[strings.ToUpper(k)] = v
+	}
+	return &result
+}
+
+// Name returns a comma separated list of the chained price source names.
+func (c *Chain) Name() string {
+	names := []string{}
+	for _, source := range c.sources {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// GetQuote returns the first successful quote from the chained price sources.
+// Pinned assets are only priced by their pinned price source.
+func (c *Chain) GetQuote(ctx context.Context, symbol string) (Quote, error) {
+	symbol = strings.ToUpper(symbol)
+	if source, ok := c.pinned[symbol]; ok {
+		quote, err := source.GetQuote(ctx, symbol)
+		if err != nil {
+			return Quote{}, fmt.Errorf("%s: %s: %v", symbol, source.Name(), err)
+		}
+		return quote, nil
+	}
+	errs := []string{}
+	for _, source := range c.sources {
+		quote, err := source.GetQuote(ctx, symbol)
+		if err == nil {
+			return quote, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", source.Name(), err))
+	}
+	return Quote{}, fmt.Errorf("%s: no price found: %s", symbol, strings.Join(errs, "; "))
+}
+
+// Prefetch prefetches unpinned asset prices from the first price source and pinned asset prices from their pinned sources.
+// Prefetch errors are ignored because unpriced assets fall back to the next price source.
+func (c *Chain) Prefetch(ctx context.Context, symbols []string) error {
+	unpinned := []string{}
+	pinned := make(map[string][]string) // Maps price source names to pinned asset symbols
+	sources := make(map[string]PriceSource)
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(symbol)
+		if source, ok := c.pinned[symbol]; ok {
+			pinned[source.Name()] = append(pinned[source.Name()], symbol)
+			sources[source.Name()] = source
+		} else {
+			unpinned = append(unpinned, symbol)
+		}
+	}
+	if len(c.sources) > 0 && len(unpinned) > 0 {
+		_ = Prefetch(ctx, c.sources[0], unpinned)
+	}
+	for name, symbols := range pinned {
+		_ = Prefetch(ctx, sources[name], symbols)
+	}
+	return nil
+}
+
+// LoadCache loads the price caches of the chained and pinned price sources.
+func (c *Chain) LoadCache() error {
+	for _, source := range c.all() {
+		if err := LoadCache(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveCache saves the price caches of the chained and pinned price sources.
+func (c *Chain) SaveCache() error {
+	for _, source := range c.all() {
+		if err := SaveCache(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// all returns the chained and pinned price sources.
+func (c *Chain) all() []PriceSource {
+	result := append([]PriceSource{}, c.sources...)
+	for _, source := range c.pinned {
+		result = append(result, source)
+	}
+	return result
+}
@@ -0,0 +1,48 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/srackham/go-utils/assert"
+)
+
+// mockSource is a price source with a fixed list of prices.
+type mockSource struct {
+	name   string
+	prices map[string]float64
+}
+
+func (s mockSource) Name() string { return s.name }
+
+func (s mockSource) GetQuote(ctx context.Context, symbol string) (Quote, error) {
+	price, ok := s.prices[symbol]
+	if !ok {
+		return Quote{}, fmt.Errorf("invalid symbol: %s", symbol)
+	}
+	return Quote{Symbol: symbol, Price: price, Source: s.name}, nil
+}
+
+func TestChain(t *testing.T) {
+	source1 := mockSource{"source1", map[string]float64{"BTC": 100_000, "ETH": 1000}}
+	source2 := mockSource{"source2", map[string]float64{"BTC": 101_000, "SML": 0.5}}
+	chain := NewChain([]PriceSource{source1, source2}, map[string]PriceSource{"eth": source2})
+	assert.Equal(t, "source1,source2", chain.Name())
+
+	quote, err := chain.GetQuote(context.Background(), "btc")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, Quote{Symbol: "BTC", Price: 100_000, Source: "source1"}, quote)
+
+	quote, err = chain.GetQuote(context.Background(), "SML")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, Quote{Symbol: "SML", Price: 0.5, Source: "source2"}, quote)
+
+	_, err = chain.GetQuote(context.Background(), "ETH")
+	assert.PassIf(t, err != nil, "pinned asset should not fall back")
+	assert.Equal(t, "ETH: source2: invalid symbol: ETH", err.Error())
+
+	_, err = chain.GetQuote(context.Background(), "XYZ")
+	assert.PassIf(t, err != nil, "unpriced asset should generate an error")
+	assert.Equal(t, "XYZ: no price found: source1: invalid symbol: XYZ; source2: invalid symbol: XYZ", err.Error())
+}
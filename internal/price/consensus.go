@@ -0,0 +1,114 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/srackham/cryptor/internal/global"
+)
+
+// Consensus is a price source that queries all its price sources and returns the median price.
+// A warning is printed if a source price deviates from the median by more than the tolerance percentage.
+type Consensus struct {
+	*Context
+	sources   []PriceSource
+	tolerance float64    // Maximum percentage price deviation from the median price
+	mu        sync.Mutex // Serialises concurrent warnings
+}
+
+// NewConsensus returns a consensus price source.
+func NewConsensus(ctx *Context, sources []PriceSource, tolerance float64) *Consensus {
+	return &Consensus{
+		Context:   ctx,
+		sources:   sources,
+		tolerance: tolerance,
+	}
+}
+
+// Name returns the price source name.
+func (c *Consensus) Name() string { return "consensus" }
+
+// GetQuote returns the median price from all price sources that successfully priced asset `symbol`.
+// The quote source lists the price sources that contributed to the median price; the quote is stale if any of the
+// contributing quotes are stale.
+func (c *Consensus) GetQuote(ctx context.Context, symbol string) (Quote, error) {
+	symbol = strings.ToUpper(symbol)
+	quotes := []Quote{}
+	errs := []string{}
+	for _, source := range c.sources {
+		quote, err := source.GetQuote(ctx, symbol)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", source.Name(), err))
+			continue
+		}
+		quotes = append(quotes, quote)
+	}
+	if len(quotes) == 0 {
+		return Quote{}, fmt.Errorf("%s: no price found: %s", symbol, strings.Join(errs, "; "))
+	}
+	sort.Slice(quotes, func(i, j int) bool {
+		return quotes[i].Price < quotes[j].Price
+	})
+	n := len(quotes)
+	median := quotes[n/2].Price
+	if n%2 == 0 {
+		median = (quotes[n/2-1].Price + quotes[n/2].Price) / 2
+	}
+	result := Quote{
+		Symbol: symbol,
+		Price:  median,
+	}
+	names := []string{}
+	for _, q := range quotes {
+		names = append(names, q.Source)
+		result.Stale = result.Stale || q.Stale
+	}
+	sort.Strings(names)
+	result.Source = strings.Join(names, ",")
+	if median != 0 {
+		result.Spread = (quotes[n-1].Price - quotes[0].Price) / median * 100
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, q := range quotes {
+			deviation := math.Abs(q.Price-median) / median * 100
+			if deviation > c.tolerance {
+				fmt.Fprintf(c.Stderr, "WARNING: %s: %s price %.2f USD deviates %.2f%% from the median price %.2f USD\n",
+					symbol, q.Source, q.Price, deviation, median)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Prefetch prefetches asset prices from all price sources.
+// Prefetch errors are ignored because unpriced assets are reported by GetQuote.
+func (c *Consensus) Prefetch(ctx context.Context, symbols []string) error {
+	for _, source := range c.sources {
+		_ = Prefetch(ctx, source, symbols)
+	}
+	return nil
+}
+
+// LoadCache loads the price caches of the consensus price sources.
+func (c *Consensus) LoadCache() error {
+	for _, source := range c.sources {
+		if err := LoadCache(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveCache saves the price caches of the consensus price sources.
+func (c *Consensus) SaveCache() error {
+	for _, source := range c.sources {
+		if err := SaveCache(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package price
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestConsensus(t *testing.T) {
+	ctx := mock.NewContext()
+	source1 := mockSource{"source1", map[string]float64{"BTC": 100_000, "ETH": 1000}}
+	source2 := mockSource{"source2", map[string]float64{"BTC": 101_000, "ETH": 1010}}
+	source3 := mockSource{"source3", map[string]float64{"BTC": 110_000}}
+	consensus := NewConsensus(&ctx, []PriceSource{source1, source2, source3}, 5.0)
+	assert.Equal(t, "consensus", consensus.Name())
+
+	quote, err := consensus.GetQuote(context.Background(), "BTC")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "BTC", quote.Symbol)
+	assert.Equal(t, 101_000.0, quote.Price)
+	assert.Equal(t, "source1,source2,source3", quote.Source)
+	assert.Equal(t, "9.90", fmt.Sprintf("%.2f", quote.Spread))
+	stderr := ctx.Stderr.(*bytes.Buffer).String()
+	assert.Equal(t, "WARNING: BTC: source3 price 110000.00 USD deviates 8.91% from the median price 101000.00 USD\n", stderr)
+
+	quote, err = consensus.GetQuote(context.Background(), "eth")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1005.0, quote.Price)
+	assert.Equal(t, "source1,source2", quote.Source)
+
+	_, err = consensus.GetQuote(context.Background(), "XYZ")
+	assert.PassIf(t, err != nil, "unpriced asset should generate an error")
+	assert.Contains(t, err.Error(), "XYZ: no price found: source1: invalid symbol: XYZ")
+}
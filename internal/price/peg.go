@@ -0,0 +1,134 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	. "github.com/srackham/cryptor/internal/global"
+)
+
+// DefaultPegs maps well known stablecoin symbols to the fiat currencies they are pegged to.
+var DefaultPegs = map[string]string{
+	"DAI":   "USD",
+	"EURC":  "EUR",
+	"FDUSD": "USD",
+	"USDC":  "USD",
+	"USDT":  "USD",
+}
+
+// PegRate returns the USD value of one unit of fiat `currency`.
+type PegRate func(currency string) (float64, error)
+
+// Pegged is a price source that wraps a market price source and prices stablecoins.
+// Stablecoins are valued at either their market price or their peg price (the USD value of the pegged fiat currency).
+// A warning is printed if a stablecoin's market price deviates from its peg price by more than the threshold percentage.
+type Pegged struct {
+	*Context
+	source    PriceSource
+	pegs      map[string]string // Maps stablecoin symbols to pegged fiat currency symbols
+	rate      PegRate
+	UsePeg    bool       // If true stablecoins are valued at their peg price
+	threshold float64    // Maximum percentage market price deviation from the peg price
+	mu        sync.Mutex // Serialises peg rate lookups and concurrent warnings
+}
+
+// NewPegged returns a stablecoin price source that wraps the `source` market price source.
+func NewPegged(ctx *Context, source PriceSource, pegs map[string]string, rate PegRate, threshold float64) *Pegged {
+	result := &Pegged{
+		Context:   ctx,
+		source:    source,
+		pegs:      make(map[string]string),
+		rate:      rate,
+		threshold: threshold,
+	}
+	for k, v := range pegs {
+		result.pegs[strings.ToUpper(k)] = strings.ToUpper(v)
+	}
+	return result
+}
+
+// Name returns the name of the wrapped market price source.
+func (p *Pegged) Name() string { return p.source.Name() }
+
+// Source returns the wrapped market price source.
+func (p *Pegged) Source() PriceSource { return p.source }
+
+// pegPrice returns the USD value of the fiat currency that stablecoin `symbol` is pegged to.
+func (p *Pegged) pegPrice(symbol string) (float64, error) {
+	currency := p.pegs[symbol]
+	if currency == "USD" {
+		return 1.0, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate, err := p.rate(currency)
+	if err != nil {
+		return 0, fmt.Errorf("%s peg: %v", currency, err)
+	}
+	return rate, nil
+}
+
+// GetQuote returns the price of asset `symbol`; assets that are not stablecoins are priced by the market price source.
+// In peg mode market price errors are reported as warnings because the market price is only used to check the peg.
+func (p *Pegged) GetQuote(ctx context.Context, symbol string) (Quote, error) {
+	symbol = strings.ToUpper(symbol)
+	if _, ok := p.pegs[symbol]; !ok {
+		return p.source.GetQuote(ctx, symbol)
+	}
+	peg, pegErr := p.pegPrice(symbol)
+	quote, err := p.source.GetQuote(ctx, symbol)
+	if err == nil {
+		p.checkPeg(quote, peg, pegErr)
+	}
+	if p.UsePeg {
+		if err != nil && pegErr == nil {
+			p.mu.Lock()
+			fmt.Fprintf(p.Stderr, "WARNING: %s: unable to check peg: %v\n", symbol, err)
+			p.mu.Unlock()
+		}
+		if pegErr != nil {
+			return Quote{}, fmt.Errorf("%s: %v", symbol, pegErr)
+		}
+		return Quote{Symbol: symbol, Price: peg, Source: "peg"}, nil
+	}
+	if err != nil {
+		return Quote{}, err
+	}
+	return quote, nil
+}
+
+// checkPeg prints a warning if the `quote` market price deviates from the `peg` price by more than the threshold.
+func (p *Pegged) checkPeg(quote Quote, peg float64, pegErr error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	currency := p.pegs[quote.Symbol]
+	if pegErr != nil {
+		if !p.UsePeg {
+			fmt.Fprintf(p.Stderr, "WARNING: %s: unable to check peg: %v\n", quote.Symbol, pegErr)
+		}
+		return
+	}
+	deviation := (quote.Price - peg) / peg * 100
+	if math.Abs(deviation) > p.threshold {
+		fmt.Fprintf(p.Stderr, "WARNING: %s: %s price %.4f USD deviates %.2f%% from its %s peg price %.4f USD\n",
+			quote.Symbol, quote.Source, quote.Price, deviation, currency, peg)
+	}
+}
+
+// Prefetch prefetches asset prices from the market price source.
+func (p *Pegged) Prefetch(ctx context.Context, symbols []string) error {
+	return Prefetch(ctx, p.source, symbols)
+}
+
+// LoadCache loads the market price source price caches.
+func (p *Pegged) LoadCache() error {
+	return LoadCache(p.source)
+}
+
+// SaveCache saves the market price source price caches.
+func (p *Pegged) SaveCache() error {
+	return SaveCache(p.source)
+}
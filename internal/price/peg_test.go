@@ -0,0 +1,60 @@
+package price
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestPegged(t *testing.T) {
+	ctx := mock.NewContext()
+	source := mockSource{"source1", map[string]float64{"BTC": 100_000, "USDC": 0.999, "USDT": 0.95, "EURC": 1.10}}
+	rate := func(currency string) (float64, error) {
+		if currency == "EUR" {
+			return 1.10, nil
+		}
+		return 0, fmt.Errorf("unknown currency: %s", currency)
+	}
+	pegs := map[string]string{"usdc": "usd", "USDT": "USD", "EURC": "EUR", "XYZ": "XXX", "DAI": "USD"}
+	pegged := NewPegged(&ctx, source, pegs, rate, 0.5)
+	assert.Equal(t, "source1", pegged.Name())
+
+	quote, err := pegged.GetQuote(context.Background(), "BTC")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 100_000.0, quote.Price)
+
+	// Market prices.
+	quote, err = pegged.GetQuote(context.Background(), "usdc")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, Quote{Symbol: "USDC", Price: 0.999, Source: "source1"}, quote)
+	quote, err = pegged.GetQuote(context.Background(), "EURC")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.10, quote.Price)
+	stderr := ctx.Stderr.(*bytes.Buffer)
+	assert.Equal(t, "", stderr.String())
+	quote, err = pegged.GetQuote(context.Background(), "USDT")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 0.95, quote.Price)
+	assert.Equal(t, "WARNING: USDT: source1 price 0.9500 USD deviates -5.00% from its USD peg price 1.0000 USD\n", stderr.String())
+
+	// Peg prices.
+	stderr.Reset()
+	pegged.UsePeg = true
+	quote, err = pegged.GetQuote(context.Background(), "USDT")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, Quote{Symbol: "USDT", Price: 1.0, Source: "peg"}, quote)
+	assert.Contains(t, stderr.String(), "WARNING: USDT:")
+	// A warning is printed if the peg cannot be checked.
+	stderr.Reset()
+	quote, err = pegged.GetQuote(context.Background(), "DAI")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.0, quote.Price)
+	assert.Contains(t, stderr.String(), "WARNING: DAI: unable to check peg:")
+	quote, err = pegged.GetQuote(context.Background(), "XYZ")
+	assert.PassIf(t, err != nil, "unknown peg currency should generate an error")
+	assert.Equal(t, "XYZ: XXX peg: unknown currency: XXX", err.Error())
+}
@@ -0,0 +1,92 @@
+// Package price defines the crypto currency price source interface.
+package price
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Quote is the USD price of one unit of a crypto currency asset.
+type Quote struct {
+	Symbol string  // Asset symbol
+	Price  float64 // Unit price in USD
+	Source string  // Name of the price source that supplied the price
+	Spread float64 // Percentage spread between the highest and lowest prices (consensus prices only)
+	Route  string  // Trading pairs used to derive the price e.g. "XYZBTC*BTCUSDT" (derived prices only)
+	Stale  bool    // True if the price is an offline cached price
+}
+
+// PriceSource is implemented by crypto currency price oracles.
+type PriceSource interface {
+	// Name returns the price source name e.g. "binance".
+	Name() string
+	// GetQuote returns the current USD price of asset `symbol`.
+	// Network requests are cancelled if `ctx` is cancelled.
+	GetQuote(ctx context.Context, symbol string) (Quote, error)
+}
+
+// Prefetcher is implemented by price sources that can fetch the prices of multiple assets with a single request.
+type Prefetcher interface {
+	// Prefetch fetches and caches the current USD prices of assets `symbols`.
+	Prefetch(ctx context.Context, symbols []string) error
+}
+
+// Prefetch fetches and caches the prices of assets `symbols` if the `source` implements the Prefetcher interface.
+func Prefetch(ctx context.Context, source PriceSource, symbols []string) error {
+	if p, ok := source.(Prefetcher); ok {
+		return p.Prefetch(ctx, symbols)
+	}
+	return nil
+}
+
+// CachePersister is implemented by price sources that persist fetched prices to a cache file.
+type CachePersister interface {
+	LoadCache() error
+	SaveCache() error
+}
+
+// LoadCache loads the `source` price cache file if the source implements the CachePersister interface.
+func LoadCache(source PriceSource) error {
+	if p, ok := source.(CachePersister); ok {
+		return p.LoadCache()
+	}
+	return nil
+}
+
+// SaveCache saves the `source` price cache file if the source implements the CachePersister interface.
+func SaveCache(source PriceSource) error {
+	if p, ok := source.(CachePersister); ok {
+		return p.SaveCache()
+	}
+	return nil
+}
+
+// Historical is implemented by price sources that can price assets on a past date.
+type Historical interface {
+	// GetHistoricalQuote returns the USD closing price of asset `symbol` on the UTC `date`.
+	GetHistoricalQuote(ctx context.Context, symbol string, date time.Time) (Quote, error)
+}
+
+// OnDate is a price source that prices assets on a past date using a historical price source.
+type OnDate struct {
+	source PriceSource
+	date   time.Time
+}
+
+// NewOnDate returns a price source that prices assets with the `source` price source on `date`.
+func NewOnDate(source PriceSource, date time.Time) *OnDate {
+	return &OnDate{source: source, date: date}
+}
+
+// Name returns the historical price source name.
+func (d *OnDate) Name() string { return d.source.Name() }
+
+// GetQuote returns the USD closing price of asset `symbol` on the historical date.
+func (d *OnDate) GetQuote(ctx context.Context, symbol string) (Quote, error) {
+	h, ok := d.source.(Historical)
+	if !ok {
+		return Quote{}, fmt.Errorf("%s: historical prices are not supported", d.source.Name())
+	}
+	return h.GetHistoricalQuote(ctx, symbol, d.date)
+}
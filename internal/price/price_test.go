@@ -0,0 +1,18 @@
+package price
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestOnDate(t *testing.T) {
+	source := mockSource{"source1", map[string]float64{"BTC": 100_000}}
+	onDate := NewOnDate(source, time.Date(2000, 6, 30, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, "source1", onDate.Name())
+	_, err := onDate.GetQuote(context.Background(), "BTC")
+	assert.PassIf(t, err != nil, "unsupported historical prices should generate an error")
+	assert.Equal(t, "source1: historical prices are not supported", err.Error())
+}
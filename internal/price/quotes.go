@@ -0,0 +1,70 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Quotes maps asset symbols to price quotes, it implements the PriceSource interface.
+type Quotes map[string]Quote
+
+// Name returns the price source name.
+func (q Quotes) Name() string { return "quotes" }
+
+// GetQuote returns the quote for asset `symbol`.
+func (q Quotes) GetQuote(ctx context.Context, symbol string) (Quote, error) {
+	quote, ok := q[strings.ToUpper(symbol)]
+	if !ok {
+		return Quote{}, fmt.Errorf("missing price: %s", symbol)
+	}
+	return quote, nil
+}
+
+// FetchQuotes fetches the quotes for assets `symbols` from the `source` using a pool of `concurrency` workers.
+// If a request fails the outstanding requests are cancelled and the error is returned.
+// All requests are cancelled if the `parent` context is cancelled or its deadline expires.
+func FetchQuotes(parent context.Context, source PriceSource, symbols []string, concurrency int) (Quotes, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	result := make(Quotes)
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for symbol := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				quote, err := source.GetQuote(ctx, symbol)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					result[strings.ToUpper(symbol)] = quote
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, symbol := range symbols {
+		jobs <- symbol
+	}
+	close(jobs)
+	wg.Wait()
+	if err := parent.Err(); err != nil {
+		return nil, fmt.Errorf("price requests cancelled: %v", err)
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package price
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/srackham/go-utils/assert"
+)
+
+// slowSource is a price source that records the maximum number of concurrent requests.
+type slowSource struct {
+	delay   time.Duration
+	active  atomic.Int32
+	maxSeen atomic.Int32
+}
+
+func (s *slowSource) Name() string { return "slow" }
+
+func (s *slowSource) GetQuote(ctx context.Context, symbol string) (Quote, error) {
+	n := s.active.Add(1)
+	defer s.active.Add(-1)
+	for {
+		m := s.maxSeen.Load()
+		if n <= m || s.maxSeen.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	select {
+	case <-time.After(s.delay):
+		return Quote{Symbol: symbol, Price: 1.0, Source: s.Name()}, nil
+	case <-ctx.Done():
+		return Quote{}, ctx.Err()
+	}
+}
+
+func TestFetchQuotes(t *testing.T) {
+	source := mockSource{"source1", map[string]float64{"BTC": 100_000, "ETH": 1000}}
+	quotes, err := FetchQuotes(context.Background(), source, []string{"BTC", "ETH"}, 4)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2, len(quotes))
+	quote, err := quotes.GetQuote(context.Background(), "ETH")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1000.0, quote.Price)
+	_, err = quotes.GetQuote(context.Background(), "XYZ")
+	assert.Equal(t, "missing price: XYZ", err.Error())
+
+	_, err = FetchQuotes(context.Background(), source, []string{"BTC", "XYZ"}, 4)
+	assert.Equal(t, "invalid symbol: XYZ", err.Error())
+}
+
+func TestFetchQuotesConcurrency(t *testing.T) {
+	source := &slowSource{delay: 10 * time.Millisecond}
+	symbols := []string{"A", "B", "C", "D", "E", "F", "G", "H"}
+	quotes, err := FetchQuotes(context.Background(), source, symbols, 3)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 8, len(quotes))
+	assert.PassIf(t, source.maxSeen.Load() <= 3, "too many concurrent requests: %d", source.maxSeen.Load())
+	assert.PassIf(t, source.maxSeen.Load() > 1, "requests were not concurrent")
+}
+
+func TestFetchQuotesDeadline(t *testing.T) {
+	source := &slowSource{delay: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := FetchQuotes(ctx, source, []string{"A", "B", "C"}, 2)
+	assert.Equal(t, "price requests cancelled: context deadline exceeded", err.Error())
+}
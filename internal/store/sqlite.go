@@ -0,0 +1,265 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+
+	"github.com/srackham/cryptor/internal/portfolio"
+	"github.com/srackham/go-utils/fsx"
+	_ "modernc.org/sqlite" // Pure Go SQLite driver (no CGO)
+)
+
+// SQLiteStore is an SQLite valuations database with portfolio valuations and asset tables.
+// The database is opened (and created if necessary) when it is first accessed.
+type SQLiteStore struct {
+	fname string
+	db    *sql.DB
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS valuations (
+	id            INTEGER PRIMARY KEY,
+	name          TEXT    NOT NULL,
+	notes         TEXT    NOT NULL,
+	date          TEXT    NOT NULL,
+	time          TEXT    NOT NULL,
+	value         REAL    NOT NULL,
+	cost          REAL    NOT NULL,
+	cost_amount   REAL    NOT NULL,
+	cost_currency TEXT    NOT NULL,
+	cost_date     TEXT    NOT NULL,
+	fx_gains      REAL    NOT NULL,
+	realized      REAL    NOT NULL,
+	stale         INTEGER NOT NULL,
+	synthesized   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS valuations_date ON valuations (date);
+CREATE INDEX IF NOT EXISTS valuations_name ON valuations (name, date);
+CREATE TABLE IF NOT EXISTS assets (
+	valuation_id INTEGER NOT NULL REFERENCES valuations (id) ON DELETE CASCADE,
+	position     INTEGER NOT NULL,
+	symbol       TEXT    NOT NULL,
+	price        REAL    NOT NULL,
+	spread       REAL    NOT NULL,
+	route        TEXT    NOT NULL,
+	amount       REAL    NOT NULL,
+	value        REAL    NOT NULL,
+	allocation   REAL    NOT NULL,
+	cost         REAL    NOT NULL,
+	gains        REAL    NOT NULL,
+	gains_pct    REAL    NOT NULL,
+	realized     REAL    NOT NULL,
+	PRIMARY KEY (valuation_id, position)
+);
+CREATE INDEX IF NOT EXISTS assets_symbol ON assets (symbol, valuation_id);
+`
+
+func (s *SQLiteStore) Name() string { return s.fname }
+
+func (s *SQLiteStore) Exists() bool { return fsx.FileExists(s.fname) }
+
+// open opens the database and creates the tables if they do not exist.
+func (s *SQLiteStore) open() error {
+	if s.db != nil {
+		return nil
+	}
+	db, err := sql.Open("sqlite", s.fname+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+func (s *SQLiteStore) Load() (portfolio.Portfolios, error) {
+	res := portfolio.Portfolios{}
+	err := s.Query(Query{}, func(p portfolio.Portfolio) error {
+		res = append(res, p)
+		return nil
+	})
+	return res, err
+}
+
+// Query streams the selected valuations; the query is evaluated by the database using the date, name and symbol indexes.
+func (s *SQLiteStore) Query(q Query, fn func(portfolio.Portfolio) error) error {
+	if !s.Exists() {
+		return nil
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	where := []string{}
+	args := []any{}
+	if q.From != "" {
+		where = append(where, "v.date >= ?")
+		args = append(args, q.From)
+	}
+	if q.To != "" {
+		where = append(where, "v.date <= ?")
+		args = append(args, q.To)
+	}
+	if len(q.Names) > 0 {
+		where = append(where, "v.name IN ("+placeholders(len(q.Names))+")")
+		for _, name := range q.Names {
+			args = append(args, name)
+		}
+	}
+	if len(q.Symbols) > 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM assets s WHERE s.valuation_id = v.id AND s.symbol IN ("+placeholders(len(q.Symbols))+"))")
+		for _, symbol := range q.Symbols {
+			args = append(args, symbol)
+		}
+	}
+	query := `SELECT v.id, v.name, v.notes, v.date, v.time, v.value, v.cost, v.cost_amount, v.cost_currency, v.cost_date,
+	v.fx_gains, v.realized, v.stale, v.synthesized, a.symbol, a.price, a.spread, a.route, a.amount, a.value, a.allocation,
+	a.cost, a.gains, a.gains_pct, a.realized
+	FROM valuations v LEFT JOIN assets a ON a.valuation_id = v.id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY v.id, a.position"
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	// Rows are grouped by valuation; each valuation is passed to `fn` once all of its asset rows have been read.
+	var p portfolio.Portfolio
+	id := int64(-1)
+	for rows.Next() {
+		var rowId int64
+		var v portfolio.Portfolio
+		var symbol, route sql.NullString
+		var price, spread, amount, value, allocation, cost, gains, gainsPct, realized sql.NullFloat64
+		err := rows.Scan(&rowId, &v.Name, &v.Notes, &v.Date, &v.Time, &v.Value, &v.Cost, &v.CostAmount, &v.CostCurrency, &v.CostDate,
+			&v.FXGains, &v.Realized, &v.Stale, &v.Synthesized, &symbol, &price, &spread, &route, &amount, &value, &allocation,
+			&cost, &gains, &gainsPct, &realized)
+		if err != nil {
+			return err
+		}
+		if rowId != id {
+			if id != -1 {
+				if err := fn(p); err != nil {
+					return err
+				}
+			}
+			id = rowId
+			p = v
+			p.Assets = portfolio.Assets{}
+		}
+		if symbol.Valid {
+			p.Assets = append(p.Assets, portfolio.Asset{Symbol: symbol.String, Price: price.Float64, Spread: spread.Float64,
+				Route: route.String, Amount: amount.Float64, Value: value.Float64, Allocation: allocation.Float64,
+				Cost: cost.Float64, Gains: gains.Float64, GainsPct: gainsPct.Float64, Realized: realized.Float64})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if id != -1 {
+		return fn(p)
+	}
+	return nil
+}
+
+// Append inserts the valuations in a single transaction.
+func (s *SQLiteStore) Append(ps portfolio.Portfolios) error {
+	return s.write(ps, false)
+}
+
+// Save replaces the stored valuations in a single transaction.
+func (s *SQLiteStore) Save(ps portfolio.Portfolios) error {
+	return s.write(ps, true)
+}
+
+// write inserts the valuations in a single transaction. If `replace` is true the stored valuations are deleted and
+// the database is first copied to a backup file (the database file name with a .bak extension); appends do not update
+// the backup file.
+func (s *SQLiteStore) write(ps portfolio.Portfolios, replace bool) (err error) {
+	if err := s.open(); err != nil {
+		return err
+	}
+	if replace {
+		if err := s.backup(); err != nil {
+			return err
+		}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if replace {
+		if _, err = tx.Exec("DELETE FROM assets; DELETE FROM valuations"); err != nil {
+			return err
+		}
+	}
+	insertValuation, err := tx.Prepare(`INSERT INTO valuations (name, notes, date, time, value, cost, cost_amount, cost_currency,
+	cost_date, fx_gains, realized, stale, synthesized) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertValuation.Close()
+	insertAsset, err := tx.Prepare(`INSERT INTO assets (valuation_id, position, symbol, price, spread, route, amount, value,
+	allocation, cost, gains, gains_pct, realized) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertAsset.Close()
+	for _, p := range ps {
+		var result sql.Result
+		result, err = insertValuation.Exec(p.Name, p.Notes, p.Date, p.Time, p.Value, p.Cost, p.CostAmount, p.CostCurrency,
+			p.CostDate, p.FXGains, p.Realized, p.Stale, p.Synthesized)
+		if err != nil {
+			return err
+		}
+		var id int64
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		for i, a := range p.Assets {
+			_, err = insertAsset.Exec(id, i, a.Symbol, a.Price, a.Spread, a.Route, a.Amount, a.Value, a.Allocation,
+				a.Cost, a.Gains, a.GainsPct, a.Realized)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// backup atomically replaces the database backup file with a copy of the database.
+func (s *SQLiteStore) backup() error {
+	tmp := s.fname + ".bak.tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := s.db.Exec("VACUUM INTO ?", tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.fname+".bak")
+}
+
+func (s *SQLiteStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// placeholders returns `n` comma separated SQL parameter placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,132 @@
+// Saved portfolio valuations stores.
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/cryptor/internal/portfolio"
+	"github.com/srackham/go-utils/fsx"
+)
+
+// Valuation store formats.
+const (
+	JSON   = "json"   // JSON array file
+	JSONL  = "jsonl"  // Append-only JSON Lines file
+	YAML   = "yaml"   // YAML file
+	SQLITE = "sqlite" // SQLite database
+)
+
+// ValuationStore reads and writes saved portfolio valuations.
+// Valuations are read in the order that they were written.
+type ValuationStore interface {
+	Name() string                                            // The store's file name
+	Exists() bool                                            // True if the store has been created
+	Load() (portfolio.Portfolios, error)                     // Load all valuations
+	Query(q Query, fn func(portfolio.Portfolio) error) error // Stream the valuations selected by query `q`
+	Append(ps portfolio.Portfolios) error                    // Append valuations
+	Save(ps portfolio.Portfolios) error                      // Replace all valuations
+	Close() error                                            // Release store resources
+}
+
+// Query selects saved valuations; blank and empty query fields are not used to select valuations.
+type Query struct {
+	From    string   // Earliest valuation date formatted "YYYY-MM-DD"
+	To      string   // Latest valuation date formatted "YYYY-MM-DD"
+	Names   []string // Portfolio names
+	Symbols []string // Asset symbols (valuations holding one or more of the assets)
+}
+
+// Match returns true if valuation `p` is selected by the query.
+func (q Query) Match(p portfolio.Portfolio) bool {
+	if (q.From != "" && p.Date < q.From) || (q.To != "" && p.Date > q.To) {
+		return false
+	}
+	if len(q.Names) > 0 && !slices.Contains(q.Names, p.Name) {
+		return false
+	}
+	if len(q.Symbols) > 0 {
+		for _, a := range p.Assets {
+			if slices.Contains(q.Symbols, a.Symbol) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// IsFormat returns true if `format` is a valuation store format.
+func IsFormat(format string) bool {
+	return slices.Contains([]string{JSON, JSONL, YAML, SQLITE}, format)
+}
+
+// Filename returns the name of the `format` valuations store file in directory `dir`.
+func Filename(dir string, format string) string {
+	ext := format
+	if format == SQLITE {
+		ext = "db"
+	}
+	return filepath.Join(dir, "valuations."+ext)
+}
+
+// New returns a `format` valuation store backed by file `fname`.
+func New(ctx *Context, format string, fname string) (ValuationStore, error) {
+	switch format {
+	case JSON, JSONL, YAML:
+		if filepath.Ext(fname) != "."+format {
+			return nil, fmt.Errorf("%s valuations file must have a .%s extension: \"%s\"", format, format, fname)
+		}
+		return &FileStore{Context: ctx, fname: fname}, nil
+	case SQLITE:
+		return &SQLiteStore{fname: fname}, nil
+	default:
+		return nil, fmt.Errorf("invalid valuations store: \"%s\"", format)
+	}
+}
+
+// FileStore is a JSON, JSON Lines or YAML valuations file (the format is determined by the file name extension).
+// Malformed last line warnings are written to the context's stderr.
+type FileStore struct {
+	*Context
+	fname string
+}
+
+func (s *FileStore) Name() string { return s.fname }
+
+func (s *FileStore) Exists() bool { return fsx.FileExists(s.fname) }
+
+func (s *FileStore) Load() (portfolio.Portfolios, error) {
+	if !s.Exists() {
+		return portfolio.Portfolios{}, nil
+	}
+	res := portfolio.Portfolios{}
+	err := portfolio.ReadValuations(s.fname, s.Stderr, func(p portfolio.Portfolio) error {
+		res = append(res, p)
+		return nil
+	})
+	return res, err
+}
+
+// Query streams the selected valuations from JSON and JSON Lines files (YAML files are read in their entirety).
+func (s *FileStore) Query(q Query, fn func(portfolio.Portfolio) error) error {
+	if !s.Exists() {
+		return nil
+	}
+	return portfolio.ReadValuations(s.fname, s.Stderr, func(p portfolio.Portfolio) error {
+		if q.Match(p) {
+			return fn(p)
+		}
+		return nil
+	})
+}
+
+func (s *FileStore) Append(ps portfolio.Portfolios) error {
+	return ps.AppendValuations(s.fname, s.Stderr)
+}
+
+func (s *FileStore) Save(ps portfolio.Portfolios) error { return ps.SaveValuations(s.fname) }
+
+func (s *FileStore) Close() error { return nil }
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/cryptor/internal/portfolio"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestValuationStores(t *testing.T) {
+	ctx := mock.NewContext()
+	tmpdir := mock.MkdirTemp(t)
+	valuations, err := portfolio.LoadValuations(filepath.Join(ctx.DataDir, "valuations.json"))
+	assert.PassIf(t, err == nil, "%v", err)
+	// Add fields that are not in the test data.
+	valuations[0].Stale = true
+	valuations[0].CostCurrency = "NZD"
+	valuations[0].Assets[0].Route = "BTC/USDT"
+	valuations[1].Assets = portfolio.Assets{}
+	for _, format := range []string{JSON, JSONL, YAML, SQLITE} {
+		s, err := New(&ctx, format, Filename(tmpdir, format))
+		assert.PassIf(t, err == nil, "%v", err)
+		assert.PassIf(t, !s.Exists(), "%v: store should not exist", format)
+		loaded, err := s.Load()
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		assert.Equal(t, 0, len(loaded))
+
+		err = s.Save(valuations[:10])
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		// JSON Lines and SQLite appends do not rewrite the backup file.
+		bak, _ := os.ReadFile(s.Name() + ".bak")
+		err = s.Append(valuations[10:])
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		if format == JSONL || format == SQLITE {
+			data, _ := os.ReadFile(s.Name() + ".bak")
+			assert.PassIf(t, bytes.Equal(bak, data), "%v: backup file was rewritten", format)
+		}
+		loaded, err = s.Load()
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		assert.PassIf(t, reflect.DeepEqual(valuations, loaded), "%v: expected:\n%v\n\ngot:\n%v", format, valuations, loaded)
+
+		query := func(q Query) portfolio.Portfolios {
+			res := portfolio.Portfolios{}
+			err := s.Query(q, func(p portfolio.Portfolio) error {
+				res = append(res, p)
+				return nil
+			})
+			assert.PassIf(t, err == nil, "%v: %v", format, err)
+			return res
+		}
+		assert.Equal(t, 15, len(query(Query{})))
+		assert.Equal(t, 7, len(query(Query{Names: []string{"joint"}})))
+		assert.Equal(t, 14, len(query(Query{Names: []string{"joint", "personal"}})))
+		result := query(Query{From: "2022-12-03", To: "2022-12-04", Names: []string{"personal"}})
+		assert.Equal(t, 2, len(result))
+		assert.Equal(t, "2022-12-03", result[0].Date)
+		assert.Equal(t, "2022-12-04", result[1].Date)
+		assert.Equal(t, 6, len(query(Query{Symbols: []string{"ETH"}, Names: []string{"joint"}}))) // valuations[1] has no assets
+		assert.Equal(t, 0, len(query(Query{Symbols: []string{"XYZ"}})))
+
+		// Save replaces the stored valuations.
+		err = s.Save(valuations[:2])
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		loaded, err = s.Load()
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		assert.Equal(t, 2, len(loaded))
+		assert.PassIf(t, s.Close() == nil, "%v: close error", format)
+
+		// The previous version of the store is kept in a backup file.
+		restored := filepath.Join(tmpdir, "restored"+filepath.Ext(s.Name()))
+		err = os.Rename(s.Name()+".bak", restored)
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		b, err := New(&ctx, format, restored)
+		assert.PassIf(t, err == nil, "%v", err)
+		loaded, err = b.Load()
+		assert.PassIf(t, err == nil, "%v: %v", format, err)
+		assert.PassIf(t, reflect.DeepEqual(valuations, loaded), "%v: expected:\n%v\n\ngot:\n%v", format, valuations, loaded)
+		assert.PassIf(t, b.Close() == nil, "%v: close error", format)
+	}
+
+	_, err = New(&ctx, "csv", filepath.Join(tmpdir, "valuations.csv"))
+	assert.PassIf(t, err != nil, "error expected")
+	assert.EqualStrings(t, `invalid valuations store: "csv"`, err.Error())
+}
@@ -0,0 +1,107 @@
+package xrates
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/go-utils/helpers"
+)
+
+// ECB is the European Central Bank euro foreign exchange reference rates
+// (https://www.ecb.europa.eu/stats/policy_and_exchange_rates/euro_reference_exchange_rates/) exchange rates provider.
+// No API key is required.
+type ECB struct {
+	*Context
+	history []ecbDay // Historical rates from the first history download (nil if not yet downloaded)
+}
+
+// ecbEnvelope is the ECB reference rates XML feed document.
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string  `xml:"currency,attr"`
+			Rate     float64 `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ecbDay is a working day's USD rates.
+type ecbDay struct {
+	date  string
+	rates Rates
+}
+
+// Name returns the provider name.
+func (p *ECB) Name() string { return "ecb" }
+
+// GetRates implements the Provider interface.
+// The ECB publishes euro reference rates on working days, they are converted to USD rates.
+// If there are no rates for `date` then the rates for the closest preceding working day are returned.
+// The ECB history file contains the rates for every working day so it is downloaded once and all the parsed days
+// are kept for subsequent historical requests.
+func (p *ECB) GetRates(date string) (Rates, error) {
+	days := p.history
+	if date == "" || days == nil {
+		var err error
+		days, err = p.fetch(helpers.If(date == "", ECB_QUERY, ECB_HISTORY_QUERY))
+		if err != nil {
+			return nil, err
+		}
+		if date != "" {
+			p.history = days
+		}
+	}
+	// Find the latest rates on or before the date.
+	day := -1
+	for i, d := range days {
+		if (date == "" || d.date <= date) && (day == -1 || d.date > days[day].date) {
+			day = i
+		}
+	}
+	if day == -1 {
+		return nil, fmt.Errorf("no ECB exchange rates on: %s", date)
+	}
+	rates := make(Rates)
+	for k, v := range days[day].rates {
+		rates[k] = v
+	}
+	return rates, nil
+}
+
+// fetch downloads and parses the ECB reference rates XML feed `url` and returns each day's rates converted to USD rates.
+func (p *ECB) fetch(url string) ([]ecbDay, error) {
+	resp, err := p.HttpGet(url)
+	if err != nil {
+		return nil, fmt.Errorf("exchange rate request: %s: %s", url, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange rate request: %s: unexpected HTTP response status code: %d", url, resp.StatusCode)
+	}
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("exchange rates decode: %s", err.Error())
+	}
+	days := []ecbDay{}
+	for _, d := range envelope.Days {
+		euroRates := Rates{"EUR": 1.0}
+		for _, r := range d.Rates {
+			euroRates[strings.ToUpper(r.Currency)] = r.Rate
+		}
+		usd, ok := euroRates["USD"]
+		if !ok || usd == 0 {
+			return nil, fmt.Errorf("invalid exchange rate response: %s: %s: missing USD rate", url, d.Time)
+		}
+		rates := make(Rates)
+		for k, v := range euroRates {
+			rates[k] = v / usd
+		}
+		days = append(days, ecbDay{date: d.Time, rates: rates})
+	}
+	return days, nil
+}
@@ -0,0 +1,50 @@
+package xrates
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/go-utils/helpers"
+)
+
+// Frankfurter is the Frankfurter (https://frankfurter.dev/) exchange rates provider.
+// Frankfurter publishes European Central Bank reference rates and does not require an API key.
+type Frankfurter struct {
+	*Context
+}
+
+// Name returns the provider name.
+func (p *Frankfurter) Name() string { return "frankfurter" }
+
+// GetRates implements the Provider interface.
+// Frankfurter returns the rates for the closest preceding working day if there are no rates for `date`.
+func (p *Frankfurter) GetRates(date string) (Rates, error) {
+	url := FRANKFURTER_QUERY + helpers.If(date == "", "latest", date) + "?from=USD"
+	resp, err := p.HttpGet(url)
+	if err != nil {
+		return nil, fmt.Errorf("exchange rate request: %s: %s", url, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange rate request: %s: unexpected HTTP response status code: %d", url, resp.StatusCode)
+	}
+	var data struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("exchange rates decode: %s", err.Error())
+	}
+	if data.Base != "USD" || data.Rates == nil {
+		return nil, fmt.Errorf("invalid exchange rate response: %s", url)
+	}
+	rates := Rates{"USD": 1.0}
+	for k, v := range data.Rates {
+		rates[strings.ToUpper(k)] = v
+	}
+	return rates, nil
+}
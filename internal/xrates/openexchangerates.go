@@ -0,0 +1,48 @@
+package xrates
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	. "github.com/srackham/cryptor/internal/global"
+)
+
+// OpenExchangeRates is the Open Exchange Rates (https://openexchangerates.org/) exchange rates provider.
+// An Open Exchange Rates App ID is required.
+type OpenExchangeRates struct {
+	*Context
+	appId string
+}
+
+// Name returns the provider name.
+func (p *OpenExchangeRates) Name() string { return "openexchangerates" }
+
+// GetRates implements the Provider interface.
+func (p *OpenExchangeRates) GetRates(date string) (Rates, error) {
+	rates := make(Rates)
+	url := XRATES_QUERY + p.appId
+	if date != "" {
+		url = XRATES_HISTORY_QUERY + date + ".json?app_id=" + p.appId
+	}
+	resp, err := p.HttpGet(url)
+	if err != nil {
+		return rates, fmt.Errorf("exchange rate request: %s: %s", url, err.Error())
+	}
+	defer resp.Body.Close()
+
+	// See https://www.sohamkamani.com/golang/json/#decoding-json-to-maps---unstructured-data
+	var m map[string]any
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	if err != nil {
+		return rates, fmt.Errorf("exchange rates decode: %s", err.Error())
+	}
+	_, ok := m["rates"]
+	if !ok {
+		return rates, fmt.Errorf("invalid exchange rate response: %s: %v", url, m)
+	}
+	for k, v := range m["rates"].(map[string]any) {
+		rates[strings.ToUpper(k)] = v.(float64)
+	}
+	return rates, nil
+}
@@ -0,0 +1,85 @@
+package xrates
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/cryptor/internal/mock"
+	"github.com/srackham/go-utils/assert"
+)
+
+func TestProviders(t *testing.T) {
+	ctx := mock.NewContext()
+	for _, name := range []string{"openexchangerates", "ecb", "frankfurter"} {
+		t.Run(name, func(t *testing.T) {
+			provider, err := NewProvider(&ctx, name, "1234")
+			assert.PassIf(t, err == nil, "%v", err)
+			assert.Equal(t, name, provider.Name())
+
+			rates, err := provider.GetRates("")
+			assert.PassIf(t, err == nil, "%v", err)
+			assert.Equal(t, "1.00", fmt.Sprintf("%.2f", rates["USD"]))
+			assert.Equal(t, "1.60", fmt.Sprintf("%.2f", rates["AUD"]))
+			assert.Equal(t, "1.50", fmt.Sprintf("%.2f", rates["NZD"]))
+
+			rates, err = provider.GetRates("2000-06-30")
+			assert.PassIf(t, err == nil, "%v", err)
+			assert.Equal(t, "1.00", fmt.Sprintf("%.2f", rates["USD"]))
+			assert.Equal(t, "2.00", fmt.Sprintf("%.2f", rates["AUD"]))
+			assert.Equal(t, "2.50", fmt.Sprintf("%.2f", rates["NZD"]))
+		})
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	ctx := mock.NewContext()
+	provider, err := NewProvider(&ctx, "", "")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "frankfurter", provider.Name())
+
+	provider, err = NewProvider(&ctx, "", "1234")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "openexchangerates", provider.Name())
+
+	_, err = NewProvider(&ctx, "openexchangerates", "")
+	assert.PassIf(t, err != nil, "missing app ID should generate an error")
+	assert.Equal(t, "missing xrates-appid config option (openexchangerates.org App ID)", err.Error())
+
+	_, err = NewProvider(&ctx, "foobar", "")
+	assert.PassIf(t, err != nil, "invalid provider should generate an error")
+	assert.Equal(t, `invalid xrates-provider: "foobar"`, err.Error())
+}
+
+func TestECBPrecedingWorkingDay(t *testing.T) {
+	ctx := mock.NewContext()
+	urls := []string{}
+	httpGet := ctx.HttpGet
+	ctx.HttpGet = func(url string) (*http.Response, error) {
+		urls = append(urls, url)
+		return httpGet(url)
+	}
+	provider := &ECB{Context: &ctx}
+	rates, err := provider.GetRates("2000-07-02") // Sunday
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2.0, rates["AUD"])
+
+	// The history file is downloaded once.
+	rates, err = provider.GetRates("2000-06-29")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.9, rates["AUD"])
+	assert.Equal(t, 1, len(urls))
+	assert.Equal(t, ECB_HISTORY_QUERY, urls[0])
+
+	// The latest rates are always fetched.
+	rates, err = provider.GetRates("")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, "1.60", fmt.Sprintf("%.2f", rates["AUD"]))
+	assert.Equal(t, 2, len(urls))
+	assert.Equal(t, ECB_QUERY, urls[1])
+
+	_, err = provider.GetRates("2000-01-01")
+	assert.PassIf(t, err != nil, "missing rates should generate an error")
+	assert.Equal(t, "no ECB exchange rates on: 2000-01-01", err.Error())
+}
@@ -5,20 +5,38 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/srackham/cryptor/internal/config"
 	. "github.com/srackham/cryptor/internal/global"
+	"github.com/srackham/cryptor/internal/lockfile"
 	"github.com/srackham/go-utils/cache"
+	"github.com/srackham/go-utils/fsx"
+	"github.com/srackham/go-utils/helpers"
 )
 
 // Cache data types.
 type Rates map[string]float64        // Key = currency symbol; value = value in USD.
 type RatesCacheData map[string]Rates // Key = date string "YYYY-MM-DD".
 
+// Provider is implemented by fiat currency exchange rate services.
+type Provider interface {
+	// Name returns the provider name e.g. "frankfurter".
+	Name() string
+	// GetRates returns the amounts of each currency that $1 USD would buy on `date` ("YYYY-MM-DD").
+	// If `date` is blank then the latest rates are returned.
+	GetRates(date string) (Rates, error)
+}
+
+// ExchangeRates is a cached fiat currency exchange rate oracle.
 type ExchangeRates struct {
 	*Context
 	*cache.Cache[RatesCacheData]
-	url string
+	Provider  Provider          // Exchange rates provider (if nil the provider is selected by the config file)
+	MaxAge    int               // Maximum age in days of cached rates used when rates cannot be fetched
+	Offline   bool              // If true rates are not fetched, the newest cached rates are used
+	loaded    bool              // True if the rates cache file has been loaded
+	rateDates map[string]string // Maps dates to the dates of the substituted cached rates
 }
 
 func New(ctx *Context) ExchangeRates {
@@ -27,6 +45,8 @@ func New(ctx *Context) ExchangeRates {
 	data := make(RatesCacheData)
 	result.Cache = cache.New(&data)
 	result.CacheFile = filepath.Join(ctx.CacheDir, "exchange-rates.json")
+	result.MaxAge = 7
+	result.rateDates = make(map[string]string)
 	return result
 }
 
@@ -34,68 +54,167 @@ func (x *ExchangeRates) ConfigFile() string {
 	return filepath.Join(x.ConfigDir, "config.yaml")
 }
 
-// getRates executes an HTTP query to fetch a list of currency exchange rates against the USD.
-func (x *ExchangeRates) getRates() (Rates, error) {
-	rates := make(Rates)
-	if x.url == "" {
-		conf, err := config.LoadConfig(x.ConfigFile())
-		if err != nil {
-			return rates, err
-		}
-		if conf.XratesAppId == "" {
-			return rates, fmt.Errorf("missing config file xrates-appid (openexchangerates.org App ID): %v", x.ConfigFile())
-		}
-		x.url = XRATES_QUERY + conf.XratesAppId
+// NewProvider returns the named exchange rates provider.
+// If `name` is blank then the openexchangerates provider is returned if an `appId` is specified, otherwise the frankfurter provider is returned.
+func NewProvider(ctx *Context, name string, appId string) (Provider, error) {
+	if name == "" {
+		name = helpers.If(appId == "", "frankfurter", "openexchangerates")
 	}
-	resp, err := x.HttpGet(x.url)
-	if err != nil {
-		return rates, fmt.Errorf("exchange rate request: %s: %s", x.url, err.Error())
+	switch name {
+	case "openexchangerates":
+		if appId == "" {
+			return nil, fmt.Errorf("missing xrates-appid config option (openexchangerates.org App ID)")
+		}
+		return &OpenExchangeRates{Context: ctx, appId: appId}, nil
+	case "ecb":
+		return &ECB{Context: ctx}, nil
+	case "frankfurter":
+		return &Frankfurter{Context: ctx}, nil
+	default:
+		return nil, fmt.Errorf("invalid xrates-provider: \"%s\"", name)
 	}
-	defer resp.Body.Close()
+}
 
-	// See https://www.sohamkamani.com/golang/json/#decoding-json-to-maps---unstructured-data
-	var m map[string]any
-	err = json.NewDecoder(resp.Body).Decode(&m)
-	if err != nil {
-		return rates, fmt.Errorf("exchange rates decode: %s", err.Error())
-	}
-	_, ok := m["rates"]
-	if !ok {
-		return rates, fmt.Errorf("invalid exchange rate response: %s: %v", x.url, m)
-	}
-	for k, v := range m["rates"].(map[string]any) {
-		rates[strings.ToUpper(k)] = v.(float64)
+// getRates fetches a list of currency exchange rates against the USD from the exchange rates provider.
+// If `date` ("YYYY-MM-DD") is not blank then the historical rates for the date are fetched.
+func (x *ExchangeRates) getRates(date string) (Rates, error) {
+	if x.Provider == nil {
+		conf := &config.Config{}
+		if fsx.FileExists(x.ConfigFile()) {
+			var err error
+			if conf, err = config.LoadConfig(x.ConfigFile()); err != nil {
+				return nil, err
+			}
+		}
+		provider, err := NewProvider(x.Context, conf.XratesProvider, conf.XratesAppId)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %v: %s", x.ConfigFile(), err.Error())
+		}
+		x.Provider = provider
 	}
-	return rates, nil
+	return x.Provider.GetRates(date)
 }
 
 // GetCachedRate returns the amount of `currency` that $1 USD would buy at today's rates.
 // `symbol` is a currency symbol.
 // If `force` is `true` then then today's rates are unconditionally fetched and the cache updated.
-// Loads the rates cache when called for the first time or if rates for today are not in the cache.
 func (x *ExchangeRates) GetCachedRate(currency string, force bool) (float64, error) {
+	today := x.Now().Format("2006-01-02")
+	if force && !x.Offline {
+		if err := x.load(); err != nil {
+			return 0.0, err
+		}
+		delete(*x.CacheData, today)
+	}
+	return x.GetRateOn(currency, today)
+}
+
+// GetRateOn returns the amount of `currency` that $1 USD would buy at the rates on `date` ("YYYY-MM-DD").
+// Rates that are not in the cache are fetched and cached: today's rates are fetched from the latest rates endpoint,
+// earlier dates from the historical rates endpoint.
+// If the rates cannot be fetched then the newest cached rates no more than MaxAge days older than `date` are used
+// (see RateDate) and a warning is printed.
+// If Offline is true rates are not fetched: the newest cached rates dated no later than `date` are used regardless
+// of their age.
+// Loads the rates cache when called for the first time.
+func (x *ExchangeRates) GetRateOn(currency string, date string) (float64, error) {
 	if currency == "" {
 		return 0.0, fmt.Errorf("no currency specified")
 	}
+	currency = strings.ToUpper(currency)
 	if currency == "USD" {
 		return 1.00, nil
 	}
-	force = force || x.CacheData == nil
-	rate := 0.0
-	ok := false
-	today := x.Now().Format("2006-01-02")
-	if !force {
-		rate, ok = (*x.CacheData)[today][strings.ToUpper(currency)]
+	if err := x.load(); err != nil {
+		return 0.0, err
 	}
-	if !ok || force {
-		rates, err := x.getRates()
-		if err != nil {
-			return 0.0, err
+	rates, ok := (*x.CacheData)[x.RateDate(date)]
+	if !ok && x.Offline {
+		cached := x.newestCachedDate(date, true)
+		if cached == "" {
+			return 0.0, fmt.Errorf("offline: no cached exchange rates on or before: %s", date)
 		}
-		x.CacheData = &(RatesCacheData{today: rates}) // Only cache today's rates
-		if rate, ok = (*x.CacheData)[today][strings.ToUpper(currency)]; !ok {
-			return 0.0, fmt.Errorf("unknown currency: %s", currency)
+		x.rateDates[date] = cached
+		rates = (*x.CacheData)[cached]
+	} else if !ok {
+		var err error
+		rates, err = x.getRates(helpers.If(date == x.Now().Format("2006-01-02"), "", date))
+		if err != nil {
+			cached := x.newestCachedDate(date, false)
+			if cached == "" {
+				return 0.0, err
+			}
+			fmt.Fprintf(x.Stderr, "WARNING: %s: using cached %s exchange rates\n", err.Error(), cached)
+			x.rateDates[date] = cached
+			rates = (*x.CacheData)[cached]
+		} else {
+			(*x.CacheData)[date] = rates
 		}
 	}
+	rate, ok := rates[currency]
+	if !ok {
+		return 0.0, fmt.Errorf("unknown currency: %s", currency)
+	}
 	return rate, nil
 }
+
+// RateDate returns the date of the rates used for `date` ("YYYY-MM-DD") by GetRateOn; this is `date` unless
+// cached rates from an earlier date were substituted.
+func (x *ExchangeRates) RateDate(date string) string {
+	if cached, ok := x.rateDates[date]; ok {
+		return cached
+	}
+	return date
+}
+
+// newestCachedDate returns the date of the newest cached rates that are dated no later than `date` and, unless
+// `anyAge` is true, no more than MaxAge days earlier than `date`. Returns a blank string if there are no such rates.
+func (x *ExchangeRates) newestCachedDate(date string, anyAge bool) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return ""
+	}
+	oldest := ""
+	if !anyAge {
+		oldest = t.AddDate(0, 0, -x.MaxAge).Format("2006-01-02")
+	}
+	res := ""
+	for d := range *x.CacheData {
+		if d <= date && d >= oldest && d > res {
+			res = d
+		}
+	}
+	return res
+}
+
+// load reads the rates cache file the first time it is called.
+func (x *ExchangeRates) load() error {
+	if x.loaded {
+		return nil
+	}
+	x.loaded = true
+	if err := x.Load(); err != nil {
+		return fmt.Errorf("exchange rates file: \"%s\": %s", x.CacheFile, err.Error())
+	}
+	return nil
+}
+
+// Save writes the rates cache to the rates cache file.
+// The cache file is locked and rates for dates that are not in the cache are merged from the cache file, so rates
+// saved by concurrent cryptor processes are not lost. The file is written atomically (see lockfile.WriteFile).
+func (x *ExchangeRates) Save() error {
+	return lockfile.Update(x.CacheFile, func(data []byte) ([]byte, error) {
+		if data != nil {
+			saved := make(RatesCacheData)
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return nil, err
+			}
+			for date, rates := range saved {
+				if _, ok := (*x.CacheData)[date]; !ok {
+					(*x.CacheData)[date] = rates
+				}
+			}
+		}
+		return json.MarshalIndent(*x.CacheData, "", "  ")
+	})
+}
@@ -1,6 +1,8 @@
 package xrates
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/srackham/cryptor/internal/mock"
@@ -41,26 +43,134 @@ func TestExchangeRates(t *testing.T) {
 	assert.Equal(t, `{
   "2000-12-01": {
     "AUD": 1.6,
+    "EUR": 0.8,
     "NZD": 1.5,
     "USD": 1
   }
 }`, got)
 
 	rates := *x.CacheData
-	rates["1999-12-31"] = rates["2000-12-01"]
+	rates["1999-12-31"] = Rates{"AUD": 1.7}
 	assert.Equal(t, len(*(x.CacheData)), 2)
-	_, err = x.GetCachedRate("AUD", true) // Replaces rates with only today's rates
+	_, err = x.GetCachedRate("AUD", true) // Refreshes today's rates, earlier rates are kept
 	assert.PassIf(t, err == nil, "%v", err)
-	assert.Equal(t, len(*(x.CacheData)), 1)
+	assert.Equal(t, len(*(x.CacheData)), 2)
 	err = x.Save()
 	assert.PassIf(t, err == nil, "error writing exchange rates cache: \"%v\": %v", x.CacheFile, err)
 	got, err = fsx.ReadFile(x.CacheFile)
 	assert.PassIf(t, err == nil, "error reading exchange rates cache: \"%v\": %v", x.CacheFile, err)
 	assert.Equal(t, `{
+  "1999-12-31": {
+    "AUD": 1.7
+  },
   "2000-12-01": {
     "AUD": 1.6,
+    "EUR": 0.8,
     "NZD": 1.5,
     "USD": 1
   }
 }`, got)
+
+	// Cached rates are loaded from the cache file.
+	x = New(&ctx)
+	rate, err = x.GetRateOn("AUD", "1999-12-31")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.7, rate)
+
+	// Rates saved by another process are merged when the cache is saved.
+	y := New(&ctx)
+	_, err = y.GetRateOn("AUD", "2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	err = y.Save()
+	assert.PassIf(t, err == nil, "%v", err)
+	(*x.CacheData)["2000-12-02"] = Rates{"AUD": 1.8}
+	err = x.Save()
+	assert.PassIf(t, err == nil, "%v", err)
+	x = New(&ctx)
+	err = x.Load()
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 4, len(*x.CacheData))
+	assert.Equal(t, 2.0, (*x.CacheData)["2000-06-30"]["AUD"])
+	assert.PassIf(t, fsx.FileExists(x.CacheFile+".bak"), "missing backup file")
+}
+
+func TestGetRateOn(t *testing.T) {
+	ctx := mock.NewContext()
+	tmpdir := mock.MkdirTemp(t)
+	ctx.CacheDir = tmpdir
+	x := New(&ctx)
+
+	rate, err := x.GetRateOn("aud", "2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 2.0, rate)
+	assert.Equal(t, 2.0, (*x.CacheData)["2000-06-30"]["AUD"])
+
+	rate, err = x.GetRateOn("USD", "2000-06-30")
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.0, rate)
+
+	_, err = x.GetRateOn("FOOBAR", "2000-06-30")
+	assert.PassIf(t, err != nil, "should have returned error for FOOBAR currency")
+	assert.Equal(t, "unknown currency: FOOBAR", err.Error())
+}
+
+// failingProvider is an exchange rates provider that always fails.
+type failingProvider struct{}
+
+func (p failingProvider) Name() string { return "failing" }
+
+func (p failingProvider) GetRates(date string) (Rates, error) {
+	return nil, fmt.Errorf("network error")
+}
+
+func TestCachedRatesFallback(t *testing.T) {
+	ctx := mock.NewContext()
+	tmpdir := mock.MkdirTemp(t)
+	ctx.CacheDir = tmpdir
+	x := New(&ctx)
+	x.Provider = failingProvider{}
+	x.loaded = true
+	*x.CacheData = RatesCacheData{
+		"2000-11-20": Rates{"AUD": 1.7},
+		"2000-11-28": Rates{"AUD": 1.65},
+		"2000-12-02": Rates{"AUD": 1.55},
+	}
+
+	rate, err := x.GetCachedRate("AUD", false)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.65, rate)
+	assert.Equal(t, "2000-11-28", x.RateDate("2000-12-01"))
+	assert.Equal(t, "WARNING: network error: using cached 2000-11-28 exchange rates\n", ctx.Stderr.(*bytes.Buffer).String())
+	_, ok := (*x.CacheData)["2000-12-01"]
+	assert.PassIf(t, !ok, "substituted rates should not be cached")
+
+	x.MaxAge = 2
+	_, err = x.GetRateOn("AUD", "2000-11-27")
+	assert.PassIf(t, err != nil, "expired cached rates should not be used")
+	assert.Equal(t, "network error", err.Error())
+	assert.Equal(t, "2000-11-27", x.RateDate("2000-11-27"))
+}
+
+func TestOfflineRates(t *testing.T) {
+	ctx := mock.NewContext()
+	tmpdir := mock.MkdirTemp(t)
+	ctx.CacheDir = tmpdir
+	x := New(&ctx)
+	x.Provider = failingProvider{} // Offline rates must not be fetched
+	x.Offline = true
+	x.loaded = true
+	*x.CacheData = RatesCacheData{
+		"2000-10-01": Rates{"AUD": 1.7},
+		"2000-12-02": Rates{"AUD": 1.55},
+	}
+
+	rate, err := x.GetCachedRate("AUD", true)
+	assert.PassIf(t, err == nil, "%v", err)
+	assert.Equal(t, 1.7, rate)
+	assert.Equal(t, "2000-10-01", x.RateDate("2000-12-01"))
+	assert.Equal(t, "", ctx.Stderr.(*bytes.Buffer).String())
+
+	_, err = x.GetRateOn("AUD", "2000-09-30")
+	assert.PassIf(t, err != nil, "missing cached rates should generate an error")
+	assert.Equal(t, "offline: no cached exchange rates on or before: 2000-09-30", err.Error())
 }
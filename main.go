@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -20,9 +23,24 @@ func main() {
 		ConfigDir: path.Join(helpers.GetConfigDir(), "cryptor"),
 		Now:       func() time.Time { return time.Now() },
 		HttpGet:   http.Get,
+		HttpGetWithContext: func(ctx context.Context, url string) (*http.Response, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			client := http.Client{Timeout: HTTP_TIMEOUT}
+			return client.Do(req)
+		},
+		HttpPost: func(url string, contentType string, body io.Reader) (*http.Response, error) {
+			client := http.Client{Timeout: HTTP_TIMEOUT}
+			return client.Post(url, contentType, body)
+		},
 	}
-	cli := cli.New(&ctx)
-	if err := cli.Execute(os.Args...); err != nil {
+	c := cli.New(&ctx)
+	if err := c.Execute(os.Args...); err != nil {
+		if errors.Is(err, cli.ErrDriftDetected) {
+			os.Exit(2)
+		}
 		os.Exit(1)
 	}
 }